@@ -0,0 +1,347 @@
+package mmdb
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ArchiveFormat extracts the database from a downloaded file.
+type ArchiveFormat interface {
+	// Extract writes the database contained in the first size bytes of r
+	// to w.
+	Extract(r io.ReaderAt, size int64, w io.Writer) error
+}
+
+// ArchiveFormatFunc adapts a function to an ArchiveFormat.
+type ArchiveFormatFunc func(r io.ReaderAt, size int64, w io.Writer) error
+
+// Extract calls f(r, size, w).
+func (f ArchiveFormatFunc) Extract(r io.ReaderAt, size int64, w io.Writer) error {
+	return f(r, size, w)
+}
+
+var (
+	// FormatGzip reads a gzip compressed tar archive, as published by
+	// MaxMind, or a single gzip compressed database.
+	FormatGzip ArchiveFormat = gzipFormat{}
+	// FormatZstd reads a zstd compressed tar archive or a single zstd
+	// compressed database.
+	FormatZstd ArchiveFormat = zstdFormat{}
+	// FormatZip reads the first .mmdb entry of a zip archive.
+	FormatZip ArchiveFormat = zipFormat{}
+	// FormatMMDB reads an uncompressed database.
+	FormatMMDB ArchiveFormat = mmdbFormat{}
+)
+
+// formatRule selects an ArchiveFormat by file name suffix or content type.
+type formatRule struct {
+	suffix       string
+	contentTypes []string
+	format       ArchiveFormat
+}
+
+var defaultFormats = []formatRule{
+	{suffix: ".tar.gz", format: FormatGzip},
+	{suffix: ".tgz", format: FormatGzip},
+	{suffix: ".gz", contentTypes: []string{"application/gzip", "application/x-gzip"}, format: FormatGzip},
+	{suffix: ".tar.zst", format: FormatZstd},
+	{suffix: ".tzst", format: FormatZstd},
+	{suffix: ".zst", contentTypes: []string{"application/zstd"}, format: FormatZstd},
+	{suffix: ".zip", contentTypes: []string{"application/zip", "application/x-zip-compressed"}, format: FormatZip},
+	{suffix: ".mmdb", format: FormatMMDB},
+}
+
+// WithArchiveFormat registers format for files whose name ends in suffix or
+// that are served with one of contentTypes. It takes precedence over the
+// built-in formats.
+func WithArchiveFormat(suffix string, format ArchiveFormat, contentTypes ...string) Option {
+	return func(d *Downloader) {
+		rule := formatRule{suffix: suffix, contentTypes: contentTypes, format: format}
+		d.formats = append([]formatRule{rule}, d.formats...)
+	}
+}
+
+// archiveFormat picks the format of a downloaded file by its name, then by
+// its content type, and finally by sniffing its content.
+func (d *Downloader) archiveFormat(name, contentType string, r io.ReaderAt) ArchiveFormat {
+	for _, rule := range d.formats {
+		if rule.suffix != "" && strings.HasSuffix(strings.ToLower(name), rule.suffix) {
+			return rule.format
+		}
+	}
+
+	if contentType, _, err := mime.ParseMediaType(contentType); err == nil {
+		for _, rule := range d.formats {
+			for _, ct := range rule.contentTypes {
+				if strings.EqualFold(ct, contentType) {
+					return rule.format
+				}
+			}
+		}
+	}
+	return sniffFormat(r)
+}
+
+// sniffFormat picks a built-in format by the magic bytes at the start of r.
+func sniffFormat(r io.ReaderAt) ArchiveFormat {
+	magic := make([]byte, 4)
+	n, _ := r.ReadAt(magic, 0)
+	magic = magic[:n]
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return FormatGzip
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return FormatZstd
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		return FormatZip
+	default:
+		return FormatMMDB
+	}
+}
+
+type (
+	gzipFormat struct{}
+	zstdFormat struct{}
+	zipFormat  struct{}
+	mmdbFormat struct{}
+)
+
+func (gzipFormat) Extract(r io.ReaderAt, size int64, w io.Writer) error {
+	gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	return extractTarOrRaw(gz, w)
+}
+
+func (zstdFormat) Extract(r io.ReaderAt, size int64, w io.Writer) error {
+	zr, err := zstd.NewReader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	return extractTarOrRaw(zr, w)
+}
+
+// extractTarOrRaw copies the first .mmdb entry if r is a tar archive and
+// all of r otherwise.
+func extractTarOrRaw(r io.Reader, w io.Writer) error {
+	br := bufio.NewReaderSize(r, 1024)
+	// tar headers carry "ustar" at offset 257
+	if header, _ := br.Peek(262); len(header) == 262 && string(header[257:262]) == "ustar" {
+		return extractTar(br, w)
+	}
+	_, err := io.Copy(w, br)
+	return err
+}
+
+// extractTar copies the first regular .mmdb entry of a tar archive and
+// hands its notices to w. Entries other than files and directories, and
+// paths leaving the archive, are rejected.
+func extractTar(r io.Reader, w io.Writer) error {
+	tr := tar.NewReader(r)
+	found := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("tar read: %w", err)
+		}
+		if err := checkEntryName(hdr.Name); err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir, tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeReg:
+		default:
+			return fmt.Errorf("unexpected entry %s of type %q", hdr.Name, hdr.Typeflag)
+		}
+
+		switch {
+		case !found && strings.HasSuffix(hdr.Name, dbSuffix):
+			if err := copyEntry(w, tr, hdr.Name, hdr.Size); err != nil {
+				return err
+			}
+			found = true
+		case isNotice(hdr.Name) && hdr.Size <= maxNoticeSize:
+			if err := writeNotice(w, hdr.Name, tr); err != nil {
+				return err
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("no .mmdb entry found in archive")
+	}
+	return nil
+}
+
+func (zipFormat) Extract(r io.ReaderAt, size int64, w io.Writer) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, f := range zr.File {
+		if err := checkEntryName(f.Name); err != nil {
+			return err
+		}
+		mode := f.Mode()
+		if mode.IsDir() {
+			continue
+		}
+		if !mode.IsRegular() {
+			return fmt.Errorf("unexpected entry %s of mode %s", f.Name, mode)
+		}
+
+		switch {
+		case !found && strings.HasSuffix(f.Name, dbSuffix):
+			if err := copyZipEntry(w, f); err != nil {
+				return err
+			}
+			found = true
+		case isNotice(f.Name) && f.UncompressedSize64 <= maxNoticeSize:
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("zip open: %w", err)
+			}
+			err = writeNotice(w, f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("no .mmdb entry found in archive")
+	}
+	return nil
+}
+
+func copyZipEntry(w io.Writer, f *zip.File) error {
+	if f.UncompressedSize64 > math.MaxInt64 {
+		return fmt.Errorf("entry %s too large", f.Name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("zip open: %w", err)
+	}
+	defer rc.Close()
+	return copyEntry(w, rc, f.Name, int64(f.UncompressedSize64))
+}
+
+// copyEntry copies an archive entry declared to be size bytes long.
+func copyEntry(w io.Writer, r io.Reader, name string, size int64) error {
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return fmt.Errorf("extract mmdb: %w", err)
+	}
+	if n != size {
+		return fmt.Errorf("extract mmdb: %s has %d bytes, declared %d", name, n, size)
+	}
+	return nil
+}
+
+// checkEntryName rejects absolute entry paths and paths leaving the
+// archive.
+func checkEntryName(name string) error {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if clean := path.Clean(name); path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("unsafe entry path %q", name)
+	}
+	return nil
+}
+
+// maxNoticeSize bounds the notices read from an archive; larger ones are
+// not license files.
+const maxNoticeSize = 1 << 20
+
+// isNotice reports whether an archive entry is a license or copyright
+// notice, e.g. LICENSE.txt and COPYRIGHT.txt in MaxMind's archives.
+func isNotice(name string) bool {
+	base := strings.ToUpper(path.Base(name))
+	return strings.HasPrefix(base, "LICENSE") || strings.HasPrefix(base, "COPYRIGHT")
+}
+
+// writeNotice hands the notice read from r to w if w collects notices.
+func writeNotice(w io.Writer, name string, r io.Reader) error {
+	nw, ok := w.(NoticeWriter)
+	if !ok {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxNoticeSize))
+	if err != nil {
+		return fmt.Errorf("read %s: %w", name, err)
+	}
+	return nw.WriteNotice(path.Base(name), data)
+}
+
+// NoticeWriter is implemented by the writer the Downloader passes to
+// ArchiveFormat.Extract. Formats hand it the license and copyright notices
+// found next to the database, which are installed alongside it.
+type NoticeWriter interface {
+	io.Writer
+	WriteNotice(name string, data []byte) error
+}
+
+// DefaultMaxDatabaseSize is the largest database extracted unless
+// WithMaxDatabaseSize is given.
+const DefaultMaxDatabaseSize = 1 << 30
+
+// ErrDatabaseTooLarge is returned when an archive holds a database larger
+// than the configured maximum.
+var ErrDatabaseTooLarge = errors.New("mmdb: database too large")
+
+// WithMaxDatabaseSize sets the largest database extracted from an archive,
+// guarding against archives that decompress to huge files. Values below 1
+// disable the limit.
+func WithMaxDatabaseSize(n int64) Option {
+	return func(d *Downloader) {
+		d.maxSize = n
+	}
+}
+
+// extractWriter is the NoticeWriter passed to ArchiveFormat.Extract. It
+// fails writes beyond max bytes and collects the notices.
+type extractWriter struct {
+	w       io.Writer
+	n, max  int64
+	notices map[string][]byte
+}
+
+func (e *extractWriter) Write(p []byte) (int, error) {
+	if e.max > 0 && e.n+int64(len(p)) > e.max {
+		return 0, fmt.Errorf("%w: more than %s", ErrDatabaseTooLarge, formatBytes(e.max))
+	}
+	n, err := e.w.Write(p)
+	e.n += int64(n)
+	return n, err
+}
+
+func (e *extractWriter) WriteNotice(name string, data []byte) error {
+	if e.notices == nil {
+		e.notices = make(map[string][]byte)
+	}
+	e.notices[name] = data
+	return nil
+}
+
+func (mmdbFormat) Extract(r io.ReaderAt, size int64, w io.Writer) error {
+	_, err := io.Copy(w, io.NewSectionReader(r, 0, size))
+	return err
+}
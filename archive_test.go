@@ -0,0 +1,386 @@
+package mmdb
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/NoUmlautsAllowed/go-mmdb/mmdbtest"
+)
+
+// testArchives returns the City test database packed in each supported
+// format, keyed by file name.
+func testArchives(t testing.TB) (map[string][]byte, []byte) {
+	t.Helper()
+	database := mmdbtest.DefaultDatabase(CityDatabase)
+
+	tarGz, err := mmdbtest.Archive(CityDatabase, testModTime, map[string][]byte{
+		"LICENSE.txt":          []byte("license"),
+		CityDatabase + ".mmdb": database,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(tarGz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarball, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compress := func(w io.WriteCloser, buf *bytes.Buffer, data []byte) []byte {
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		return compress(gzip.NewWriter(&buf), &buf, data)
+	}
+	zstded := func(data []byte) []byte {
+		var buf bytes.Buffer
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return compress(w, &buf, data)
+	}
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for _, name := range []string{"README.txt", "dbip/" + CityDatabase + ".mmdb"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content := []byte("readme")
+		if name != "README.txt" {
+			content = database
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string][]byte{
+		"city.tar.gz":   tarGz,
+		"city.mmdb.gz":  gzipped(database),
+		"city.tar.zst":  zstded(tarball),
+		"city.mmdb.zst": zstded(database),
+		"city.zip":      zipped.Bytes(),
+		"city.mmdb":     database,
+	}, database
+}
+
+func TestArchiveFormats(t *testing.T) {
+	archives, database := testArchives(t)
+	d, _ := newTestDownloader(t)
+
+	for name, archive := range archives {
+		t.Run(name, func(t *testing.T) {
+			r := bytes.NewReader(archive)
+
+			// detected by name and by content alike
+			formats := map[string]ArchiveFormat{
+				"name":    d.archiveFormat(name, "", r),
+				"content": d.archiveFormat("download", "", r),
+			}
+			for by, format := range formats {
+				var out bytes.Buffer
+				if err := format.Extract(r, r.Size(), &out); err != nil {
+					t.Fatalf("extract (format by %s): %v", by, err)
+				}
+				if !bytes.Equal(out.Bytes(), database) {
+					t.Errorf("expected extracted database (format by %s) to equal the original", by)
+				}
+			}
+		})
+	}
+}
+
+func TestArchiveFormatSelection(t *testing.T) {
+	custom := ArchiveFormatFunc(func(io.ReaderAt, int64, io.Writer) error { return nil })
+	d, _ := newTestDownloader(t, WithArchiveFormat(".bin", custom, "application/x-custom"))
+
+	tests := []struct {
+		name     string
+		url      string
+		header   http.Header
+		content  string
+		expected ArchiveFormat
+	}{
+		{name: "MaxMind Suffix", url: "https://download.maxmind.com/geoip/databases/GeoLite2-City/download?suffix=tar.gz", expected: FormatGzip},
+		{name: "Content Disposition", url: "https://example.com/download", header: http.Header{"Content-Disposition": {`attachment; filename="city.zip"`}}, expected: FormatZip},
+		{name: "Disposition Before Path", url: "https://example.com/city.mmdb", header: http.Header{"Content-Disposition": {"attachment; filename=city.tar.zst"}}, expected: FormatZstd},
+		{name: "Path", url: "https://example.com/dbip-city-lite-2024-01.mmdb.gz", expected: FormatGzip},
+		{name: "Upper Case Path", url: "https://example.com/CITY.ZIP", expected: FormatZip},
+		{name: "Content Type", url: "https://example.com/download", header: http.Header{"Content-Type": {"application/zip; charset=binary"}}, expected: FormatZip},
+		{name: "Sniffed", url: "https://example.com/download", header: http.Header{"Content-Type": {"application/octet-stream"}}, content: "\x28\xb5\x2f\xfd", expected: FormatZstd},
+		{name: "Sniffed Raw", url: "https://example.com/download", content: "\xab\xcd\xefMaxMind.com", expected: FormatMMDB},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+			name := releaseName(tt.url, header)
+			got := d.archiveFormat(name, header.Get("Content-Type"), bytes.NewReader([]byte(tt.content)))
+			if got != tt.expected {
+				t.Errorf("expected %T, got %T", tt.expected, got)
+			}
+		})
+	}
+
+	// custom formats are function values, so compare by behaviour
+	for _, header := range []http.Header{{}, {"Content-Type": {"application/x-custom"}}} {
+		url := "https://example.com/city.bin"
+		if len(header) > 0 {
+			url = "https://example.com/download"
+		}
+		got := d.archiveFormat(releaseName(url, header), header.Get("Content-Type"), bytes.NewReader(nil))
+		if _, ok := got.(ArchiveFormatFunc); !ok {
+			t.Errorf("expected custom format for %s %v, got %T", url, header, got)
+		}
+	}
+}
+
+func TestDownloadOneThirdPartySource(t *testing.T) {
+	archives, database := testArchives(t)
+
+	var authorized bool
+	mux := http.NewServeMux()
+	for name, archive := range archives {
+		mux.HandleFunc("/"+name, func(w http.ResponseWriter, r *http.Request) {
+			if _, _, ok := r.BasicAuth(); ok {
+				authorized = true
+			}
+			http.ServeContent(w, r, name, testModTime, bytes.NewReader(archive))
+		})
+	}
+	mirror := httptest.NewServer(mux)
+	defer mirror.Close()
+
+	for name := range archives {
+		t.Run(name, func(t *testing.T) {
+			d, _ := newTestDownloader(t,
+				WithEditionURL(CityDatabase, mirror.URL+"/"+name),
+			)
+
+			if res := d.downloadOne(context.Background(), CityDatabase); res.Err != nil {
+				t.Fatalf("download: %v", res.Err)
+			}
+			got, err := os.ReadFile(dbPath(d.BasePath, CityDatabase))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, database) {
+				t.Error("expected downloaded database to equal the original")
+			}
+		})
+	}
+
+	if authorized {
+		t.Error("expected credentials not to be sent to a third-party host")
+	}
+}
+
+func TestDownloadOneChecksumUnavailable(t *testing.T) {
+	database := mmdbtest.DefaultDatabase(CityDatabase)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/city.mmdb", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "city.mmdb", testModTime, bytes.NewReader(database))
+	})
+	mux.HandleFunc("/city.mmdb.sha256", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	mirror := httptest.NewServer(mux)
+	defer mirror.Close()
+
+	// only a checksum that is not published is skipped
+	d, _ := newTestDownloader(t, WithEditionURL(CityDatabase, mirror.URL+"/city.mmdb"))
+	if res := d.downloadOne(context.Background(), CityDatabase); res.Err == nil || !strings.Contains(res.Err.Error(), "checksum") {
+		t.Errorf("expected checksum error, got %v", res.Err)
+	}
+}
+
+func TestDownloadDatabasesThirdPartyEdition(t *testing.T) {
+	const edition = "dbip-city-lite"
+	database := mmdbtest.DefaultDatabase(CityDatabase)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+edition+".mmdb", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, edition+".mmdb", testModTime, bytes.NewReader(database))
+	})
+	publisher := httptest.NewServer(mux)
+	defer publisher.Close()
+
+	d, _ := newTestDownloader(t,
+		WithEditionURL(edition, publisher.URL+"/"+edition+".mmdb"),
+		WithEditions(edition),
+	)
+	if _, err := d.DownloadDatabases(context.Background()); err != nil {
+		t.Fatalf("DownloadDatabases: %v", err)
+	}
+	got, err := os.ReadFile(dbPath(d.BasePath, edition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, database) {
+		t.Error("expected downloaded database to equal the original")
+	}
+
+	// the edition is not MaxMind's, so it needs a URL of its own
+	if _, err := NewDownloader(WithEditions(edition)); err == nil || !strings.Contains(err.Error(), "unknown edition") {
+		t.Errorf("expected unknown edition without a URL, got %v", err)
+	}
+}
+
+// tarGz packs entries into a tar.gz archive as given, including unsafe
+// ones mmdbtest.Archive would not write.
+func tarGz(t testing.TB, entries ...*tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, hdr := range entries {
+		data := mmdbtest.DefaultDatabase(CityDatabase)
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(data))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractHardening(t *testing.T) {
+	database := &tar.Header{Typeflag: tar.TypeReg, Name: "GeoLite2-City_20231114/GeoLite2-City.mmdb", Mode: 0o644}
+
+	tests := []struct {
+		name    string
+		archive []byte
+		maxSize int64
+		valid   bool
+		wantErr error
+	}{
+		{name: "Valid", archive: tarGz(t, database), maxSize: DefaultMaxDatabaseSize, valid: true},
+		{name: "Unlimited", archive: tarGz(t, database), valid: true},
+		{name: "Too Large", archive: tarGz(t, database), maxSize: 64, wantErr: ErrDatabaseTooLarge},
+		{
+			name:    "Symlink",
+			archive: tarGz(t, &tar.Header{Typeflag: tar.TypeSymlink, Name: "GeoLite2-City.mmdb", Linkname: "/etc/passwd"}, database),
+		},
+		{
+			name:    "Hard Link",
+			archive: tarGz(t, &tar.Header{Typeflag: tar.TypeLink, Name: "GeoLite2-City.mmdb", Linkname: "x"}, database),
+		},
+		{
+			name:    "Device",
+			archive: tarGz(t, &tar.Header{Typeflag: tar.TypeChar, Name: "zero", Devmajor: 1, Devminor: 5}, database),
+		},
+		{
+			name:    "Parent Path",
+			archive: tarGz(t, &tar.Header{Typeflag: tar.TypeReg, Name: "../GeoLite2-City.mmdb", Mode: 0o644}),
+		},
+		{
+			name:    "Absolute Path",
+			archive: tarGz(t, &tar.Header{Typeflag: tar.TypeReg, Name: "/tmp/GeoLite2-City.mmdb", Mode: 0o644}),
+		},
+		{
+			name:    "No Database",
+			archive: tarGz(t, &tar.Header{Typeflag: tar.TypeDir, Name: "GeoLite2-City_20231114/", Mode: 0o755}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := filepath.Join(t.TempDir(), "out.tmp")
+			_, err := extract(bytes.NewReader(tt.archive), int64(len(tt.archive)), FormatGzip, "", tmp, tt.maxSize)
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("extract: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+			if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+				t.Errorf("expected temp file to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCopyEntry(t *testing.T) {
+	var out bytes.Buffer
+	if err := copyEntry(&out, strings.NewReader("short"), "x.mmdb", 10); err == nil {
+		t.Error("expected error for an entry shorter than declared, got nil")
+	}
+	if err := copyEntry(&out, strings.NewReader("exact"), "x.mmdb", 5); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestExtractNotices(t *testing.T) {
+	archives, _ := testArchives(t)
+	archive := archives["city.tar.gz"]
+
+	notices, err := extract(bytes.NewReader(archive), int64(len(archive)), FormatGzip, "", filepath.Join(t.TempDir(), "out.tmp"), DefaultMaxDatabaseSize)
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if got := string(notices["LICENSE.txt"]); got != "license" {
+		t.Errorf("expected LICENSE.txt notice, got %q", got)
+	}
+}
+
+func TestDownloadOneNotices(t *testing.T) {
+	d, _ := newTestDownloader(t)
+	if err := d.downloadOne(context.Background(), CityDatabase).Err; err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	for _, name := range []string{"LICENSE.txt", "COPYRIGHT.txt"} {
+		data, err := os.ReadFile(noticePath(d.BasePath, CityDatabase, name))
+		if err != nil || !strings.Contains(string(data), "MaxMind") {
+			t.Errorf("expected %s next to the database, got %q, %v", name, data, err)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package mmdb
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MaxmindBackupRetention   = "MAXMIND_BACKUP_RETENTION"
+	MaxmindPinnedGenerations = "MAXMIND_PINNED_GENERATIONS"
+)
+
+// DefaultRetention is the number of backup generations kept per edition.
+const DefaultRetention = 3
+
+// ErrNoGeneration is returned when a requested backup generation does not
+// exist.
+var ErrNoGeneration = errors.New("mmdb: no such generation")
+
+// generationPath returns the path of the backup of edition with the given
+// build epoch, e.g. GeoLite2-City.1700000000.mmdb.
+func generationPath(dataDir, name string, epoch uint) string {
+	return path.Join(dataDir, fmt.Sprintf("%s.%d%s", name, epoch, dbSuffix))
+}
+
+// Generation is one build of an edition on disk, either the current
+// database or a backup of a previous one.
+type Generation struct {
+	Edition    string
+	BuildEpoch uint
+	Path       string
+	Current    bool
+}
+
+// BuildTime returns the build epoch as a time.
+func (g Generation) BuildTime() time.Time {
+	return time.Unix(int64(g.BuildEpoch), 0).UTC()
+}
+
+// WithRetention sets how many backup generations are kept per edition.
+// Zero disables backups.
+func WithRetention(n int) Option {
+	return func(d *Downloader) {
+		d.retention = n
+	}
+}
+
+// WithKeptGeneration keeps the backup of edition built at epoch regardless
+// of the retention, e.g. while a Client is pinned to it with
+// WithPinnedGeneration. Pins in MAXMIND_PINNED_GENERATIONS are kept as
+// well.
+func WithKeptGeneration(edition string, epoch uint) Option {
+	return func(d *Downloader) {
+		d.pins[edition] = epoch
+	}
+}
+
+// retentionFromEnv returns MAXMIND_BACKUP_RETENTION, or DefaultRetention if
+// it is not set.
+func retentionFromEnv() (int, error) {
+	v := os.Getenv(MaxmindBackupRetention)
+	if v == "" {
+		return DefaultRetention, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s: invalid retention %q", MaxmindBackupRetention, v)
+	}
+	return n, nil
+}
+
+// backups returns the backup generations of edition in dataDir, newest
+// first.
+func backups(dataDir, edition string) ([]Generation, error) {
+	pattern := path.Join(dataDir, edition+".*"+dbSuffix)
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var gens []Generation
+	prefix := edition + "."
+	for _, m := range matches {
+		s := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(m), prefix), dbSuffix)
+		epoch, err := strconv.ParseUint(s, 10, 0)
+		if err != nil {
+			continue
+		}
+		gens = append(gens, Generation{Edition: edition, BuildEpoch: uint(epoch), Path: m})
+	}
+	slices.SortFunc(gens, func(a, b Generation) int {
+		return cmp.Compare(b.BuildEpoch, a.BuildEpoch)
+	})
+	return gens, nil
+}
+
+// Generations returns the current database of edition followed by its
+// backups, newest first. A backup of the current build is not listed twice.
+func (d *Downloader) Generations(edition string) ([]Generation, error) {
+	gens, err := backups(d.BasePath, edition)
+	if err != nil {
+		return nil, err
+	}
+
+	cur := dbPath(d.BasePath, edition)
+	epoch, err := buildEpoch(cur)
+	if err != nil {
+		if _, statErr := os.Stat(cur); os.IsNotExist(statErr) {
+			return gens, nil
+		}
+		return nil, fmt.Errorf("open current database: %w", err)
+	}
+	gens = slices.DeleteFunc(gens, func(g Generation) bool { return g.BuildEpoch == epoch })
+	current := Generation{Edition: edition, BuildEpoch: epoch, Path: cur, Current: true}
+	return append([]Generation{current}, gens...), nil
+}
+
+// backup moves the current database of edition to its generation path and
+// prunes generations beyond the configured retention. If keep is set, the
+// current database is backed up and kept regardless of the retention.
+func (d *Downloader) backup(edition string, keep bool) error {
+	cur := dbPath(d.BasePath, edition)
+	if _, err := os.Stat(cur); os.IsNotExist(err) {
+		return d.prune(edition, 0)
+	}
+	if d.retention == 0 && !keep {
+		return d.prune(edition, 0)
+	}
+	epoch, err := buildEpoch(cur)
+	if err != nil {
+		return fmt.Errorf("read build epoch: %w", err)
+	}
+	if err := os.Rename(cur, generationPath(d.BasePath, edition, epoch)); err != nil {
+		return err
+	}
+	if !keep {
+		epoch = 0
+	}
+	return d.prune(edition, epoch)
+}
+
+// prune removes all but the newest retention backups of edition, except
+// the one built at keep if that is not zero and the one a Client is pinned
+// to.
+func (d *Downloader) prune(edition string, keep uint) error {
+	gens, err := backups(d.BasePath, edition)
+	if err != nil {
+		return err
+	}
+	pin, pinned := d.pins[edition]
+	var errs []error
+	for _, g := range gens[min(d.retention, len(gens)):] {
+		if (keep != 0 && g.BuildEpoch == keep) || (pinned && g.BuildEpoch == pin) {
+			continue
+		}
+		if err := os.Remove(g.Path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Rollback replaces the current database of edition with the backup built
+// at generation, or with the newest backup older than the current database
+// if generation is zero. The replaced database is kept as a backup itself,
+// even beyond the retention, until the next download prunes the backups.
+//
+// The saved validators of the replaced release are kept, so later downloads
+// skip that release and only fetch the next one.
+func (d *Downloader) Rollback(edition string, generation uint) (Generation, error) {
+	unlock, err := d.lockEdition(context.Background(), edition)
+	if err != nil {
+		return Generation{}, err
+	}
+	defer unlock()
+
+	gens, err := d.Generations(edition)
+	if err != nil {
+		return Generation{}, err
+	}
+
+	var cur *Generation
+	if len(gens) > 0 && gens[0].Current {
+		cur = &gens[0]
+	}
+	idx := slices.IndexFunc(gens, func(g Generation) bool {
+		if g.Current {
+			return false
+		}
+		if generation == 0 {
+			return cur == nil || g.BuildEpoch < cur.BuildEpoch
+		}
+		return g.BuildEpoch == generation
+	})
+	if idx < 0 {
+		if generation == 0 {
+			return Generation{}, fmt.Errorf("%w: no backup of %s older than the current database", ErrNoGeneration, edition)
+		}
+		return Generation{}, fmt.Errorf("%w: %s build %d", ErrNoGeneration, edition, generation)
+	}
+	target := gens[idx]
+
+	// load before the current database moves away
+	st := loadState(d.BasePath, edition)
+
+	localFile := dbPath(d.BasePath, edition)
+	tmp := localFile + ".tmp"
+	if err := copyFile(target.Path, tmp); err != nil {
+		os.Remove(tmp)
+		return Generation{}, fmt.Errorf("copy %s: %w", filepath.Base(target.Path), err)
+	}
+	if err := d.backup(edition, true); err != nil {
+		os.Remove(tmp)
+		return Generation{}, fmt.Errorf("backup: %w", err)
+	}
+	if err := os.Rename(tmp, localFile); err != nil {
+		os.Remove(tmp)
+		return Generation{}, fmt.Errorf("final rename: %w", err)
+	}
+	syncDir(d.BasePath)
+
+	st.BuildEpoch = target.BuildEpoch
+	st.SHA256 = ""
+	if err := saveState(d.BasePath, edition, st); err != nil {
+		return Generation{}, fmt.Errorf("save state: %w", err)
+	}
+	d.notify(edition)
+
+	return Generation{Edition: edition, BuildEpoch: target.BuildEpoch, Path: localFile, Current: true}, nil
+}
+
+// copyFile copies src to dst, keeping the modification time.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, time.Now(), info.ModTime())
+}
+
+// WithPinnedGeneration makes the Client open the backup of edition built at
+// epoch instead of the current database, e.g. when a fresh release turns
+// out to be bad. Until the downloader replaces it, the current database is
+// used if it is that build.
+func WithPinnedGeneration(edition string, epoch uint) ClientOption {
+	return func(c *Client) {
+		c.pins[edition] = epoch
+	}
+}
+
+// pinsFromEnv parses MAXMIND_PINNED_GENERATIONS, a comma separated list of
+// edition=build_epoch pairs.
+func pinsFromEnv() (map[string]uint, error) {
+	pins := make(map[string]uint)
+	for _, pair := range strings.FieldsFunc(os.Getenv(MaxmindPinnedGenerations), func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		edition, v, ok := strings.Cut(pair, "=")
+		epoch, err := strconv.ParseUint(v, 10, 0)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("%s: invalid pin %q, want edition=build_epoch", MaxmindPinnedGenerations, pair)
+		}
+		pins[edition] = uint(epoch)
+	}
+	return pins, nil
+}
+
+// path returns the file the Client opens for edition, honoring pins.
+func (c *Client) path(edition string) string {
+	cur := dbPath(c.DataDirectory, edition)
+	epoch, ok := c.pins[edition]
+	if !ok {
+		return cur
+	}
+	p := generationPath(c.DataDirectory, edition, epoch)
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		// the pinned build may not have been replaced yet
+		if e, err := buildEpoch(cur); err == nil && e == epoch {
+			return cur
+		}
+	}
+	return p
+}
@@ -0,0 +1,313 @@
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/NoUmlautsAllowed/go-mmdb/mmdbtest"
+)
+
+// publishRelease makes srv serve a build of edition at epoch, with a
+// Last-Modified that grows with the epoch.
+func publishRelease(t testing.TB, srv *mmdbtest.Server, edition string, epoch int64) {
+	t.Helper()
+	data, err := mmdbtest.Database(edition, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetDatabase(edition, data, testModTime.Add(time.Duration(epoch-testBuildEpoch)*time.Second))
+}
+
+// backupEpochs returns the build epochs of the backups of edition.
+func backupEpochs(t testing.TB, dir, edition string) []uint {
+	t.Helper()
+	gens, err := backups(dir, edition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var epochs []uint
+	for _, g := range gens {
+		epochs = append(epochs, g.BuildEpoch)
+	}
+	return epochs
+}
+
+func TestBackupRetention(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention int
+		expected  []uint
+	}{
+		{name: "Keep Two", retention: 2, expected: []uint{testBuildEpoch + 3*3600, testBuildEpoch + 2*3600}},
+		{name: "Keep More Than Exist", retention: 10, expected: []uint{testBuildEpoch + 3*3600, testBuildEpoch + 2*3600, testBuildEpoch + 3600, testBuildEpoch}},
+		{name: "Disabled", retention: 0, expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, srv := newTestDownloader(t, WithRetention(tt.retention))
+			for i := range 5 {
+				publishRelease(t, srv, CityDatabase, testBuildEpoch+int64(i)*3600)
+				if err := d.downloadOne(context.Background(), CityDatabase).Err; err != nil {
+					t.Fatalf("download %d: %v", i, err)
+				}
+			}
+
+			if got := backupEpochs(t, d.BasePath, CityDatabase); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected backups %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestBackupPinnedGeneration(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	t.Setenv(MaxmindPinnedGenerations, fmt.Sprintf("%s=%d", CityDatabase, testBuildEpoch))
+	d, err := NewDownloader(
+		WithURL(srv.DownloadURL()),
+		WithClient(srv.Client()),
+		WithRetention(1),
+		WithKeptGeneration(ASNDatabase, testBuildEpoch+3600),
+	)
+	if err != nil {
+		t.Fatalf("NewDownloader: %v", err)
+	}
+
+	for i := range 5 {
+		for _, edition := range []string{CityDatabase, ASNDatabase} {
+			publishRelease(t, srv, edition, testBuildEpoch+int64(i)*3600)
+			if err := d.downloadOne(context.Background(), edition).Err; err != nil {
+				t.Fatalf("download %s %d: %v", edition, i, err)
+			}
+		}
+	}
+
+	// the pinned generations outlive the retention
+	expected := map[string][]uint{
+		CityDatabase: {testBuildEpoch + 3*3600, testBuildEpoch},
+		ASNDatabase:  {testBuildEpoch + 3*3600, testBuildEpoch + 3600},
+	}
+	for edition, epochs := range expected {
+		if got := backupEpochs(t, d.BasePath, edition); !reflect.DeepEqual(got, epochs) {
+			t.Errorf("expected %s backups %v, got %v", edition, epochs, got)
+		}
+	}
+
+	c, err := NewClient(WithDataDirectory(d.BasePath), WithClientEditions(CityDatabase, ASNDatabase))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close()
+	if got := c.CityDB().Metadata.BuildEpoch; got != testBuildEpoch {
+		t.Errorf("expected client to open pinned build %d, got %d", testBuildEpoch, got)
+	}
+}
+
+func TestRetentionFromEnv(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int
+		wantErr  bool
+	}{
+		{value: "", expected: DefaultRetention},
+		{value: "0", expected: 0},
+		{value: "7", expected: 7},
+		{value: "-1", wantErr: true},
+		{value: "many", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv(MaxmindBackupRetention, tt.value)
+			got, err := retentionFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestGenerations(t *testing.T) {
+	d, srv := newTestDownloader(t)
+
+	gens, err := d.Generations(CityDatabase)
+	if err != nil || len(gens) != 0 {
+		t.Fatalf("expected no generations before the first download, got %v, %v", gens, err)
+	}
+
+	for i := range 3 {
+		publishRelease(t, srv, CityDatabase, testBuildEpoch+int64(i)*3600)
+		if err := d.downloadOne(context.Background(), CityDatabase).Err; err != nil {
+			t.Fatalf("download %d: %v", i, err)
+		}
+	}
+
+	gens, err = d.Generations(CityDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Generation{
+		{Edition: CityDatabase, BuildEpoch: testBuildEpoch + 7200, Path: dbPath(d.BasePath, CityDatabase), Current: true},
+		{Edition: CityDatabase, BuildEpoch: testBuildEpoch + 3600, Path: generationPath(d.BasePath, CityDatabase, testBuildEpoch+3600)},
+		{Edition: CityDatabase, BuildEpoch: testBuildEpoch, Path: generationPath(d.BasePath, CityDatabase, testBuildEpoch)},
+	}
+	if !reflect.DeepEqual(gens, expected) {
+		t.Errorf("expected generations %+v, got %+v", expected, gens)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	ctx := context.Background()
+
+	for i := range 3 {
+		publishRelease(t, srv, CityDatabase, testBuildEpoch+int64(i)*3600)
+		if err := d.downloadOne(ctx, CityDatabase).Err; err != nil {
+			t.Fatalf("download %d: %v", i, err)
+		}
+	}
+
+	g, err := d.Rollback(CityDatabase, 0)
+	if err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if g.BuildEpoch != testBuildEpoch+3600 || !g.Current {
+		t.Errorf("expected rollback to the previous build, got %+v", g)
+	}
+	if got, err := buildEpoch(dbPath(d.BasePath, CityDatabase)); err != nil || got != testBuildEpoch+3600 {
+		t.Errorf("expected current database of build %d, got %d, %v", testBuildEpoch+3600, got, err)
+	}
+	expected := []uint{testBuildEpoch + 7200, testBuildEpoch + 3600, testBuildEpoch}
+	if got := backupEpochs(t, d.BasePath, CityDatabase); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected backups %v, got %v", expected, got)
+	}
+
+	// the release rolled back from is not downloaded again
+	if res := d.downloadOne(ctx, CityDatabase); res.Err != nil || res.Status != StatusSkipped {
+		t.Fatalf("expected replaced release to be skipped, got %s: %v", res.Status, res.Err)
+	}
+
+	// an explicit generation, even newer than the current one
+	if g, err := d.Rollback(CityDatabase, testBuildEpoch+7200); err != nil || g.BuildEpoch != testBuildEpoch+7200 {
+		t.Fatalf("expected rollback to build %d, got %+v, %v", testBuildEpoch+7200, g, err)
+	}
+
+	publishRelease(t, srv, CityDatabase, testBuildEpoch+3*3600)
+	if res := d.downloadOne(ctx, CityDatabase); res.Err != nil || res.Status != StatusSuccess {
+		t.Fatalf("expected next release to be downloaded, got %s: %v", res.Status, res.Err)
+	}
+}
+
+func TestRollbackWithoutRetention(t *testing.T) {
+	d, _ := newTestDownloader(t, WithRetention(0))
+	if err := d.downloadOne(context.Background(), CityDatabase).Err; err != nil {
+		t.Fatal(err)
+	}
+	// left from a configuration with backups
+	old := uint(testBuildEpoch - 3600)
+	if err := mmdbtest.WriteDatabase(generationPath(d.BasePath, CityDatabase, old), CityDatabase, int64(old)); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, err := d.Rollback(CityDatabase, 0); err != nil || g.BuildEpoch != old {
+		t.Fatalf("expected rollback to build %d, got %+v, %v", old, g, err)
+	}
+	// the replaced database is kept, so that the rollback can be undone
+	if got, expected := backupEpochs(t, d.BasePath, CityDatabase), []uint{testBuildEpoch}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected backups %v, got %v", expected, got)
+	}
+	if g, err := d.Rollback(CityDatabase, testBuildEpoch); err != nil || g.BuildEpoch != testBuildEpoch {
+		t.Fatalf("expected rollback to build %d, got %+v, %v", testBuildEpoch, g, err)
+	}
+}
+
+func TestRollbackNoGeneration(t *testing.T) {
+	d, _ := newTestDownloader(t)
+	ctx := context.Background()
+
+	if _, err := d.Rollback(CityDatabase, 0); !errors.Is(err, ErrNoGeneration) {
+		t.Errorf("expected ErrNoGeneration without any database, got %v", err)
+	}
+	if err := d.downloadOne(ctx, CityDatabase).Err; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Rollback(CityDatabase, 0); !errors.Is(err, ErrNoGeneration) {
+		t.Errorf("expected ErrNoGeneration without backups, got %v", err)
+	}
+	if _, err := d.Rollback(CityDatabase, 42); !errors.Is(err, ErrNoGeneration) {
+		t.Errorf("expected ErrNoGeneration for unknown build, got %v", err)
+	}
+}
+
+func TestClientPinnedGeneration(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabases(t, dir, testBuildEpoch)
+	t.Setenv(MaxmindBasePath, dir)
+	t.Setenv(MaxmindEditionIds, "")
+	t.Setenv(MaxmindPinnedGenerations, "")
+
+	c, err := NewClient(WithPinnedGeneration(CityDatabase, testBuildEpoch))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close()
+
+	// the pinned build is still the current database
+	if got := c.CityDB().Metadata.BuildEpoch; got != testBuildEpoch {
+		t.Errorf("expected pinned build %d, got %d", testBuildEpoch, got)
+	}
+
+	// a new release moves the pinned build to a backup
+	path := dbPath(dir, CityDatabase)
+	if err := mmdbtest.WriteDatabase(generationPath(dir, CityDatabase, testBuildEpoch), CityDatabase, testBuildEpoch); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmdbtest.WriteDatabase(path, CityDatabase, testBuildEpoch+3600); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmdbtest.WriteDatabase(dbPath(dir, ASNDatabase), ASNDatabase, testBuildEpoch+3600); err != nil {
+		t.Fatal(err)
+	}
+	c.reloadAll()
+
+	if got := c.CityDB().Metadata.BuildEpoch; got != testBuildEpoch {
+		t.Errorf("expected pinned build %d after reload, got %d", testBuildEpoch, got)
+	}
+	if got := c.AsnDB().Metadata.BuildEpoch; got != testBuildEpoch+3600 {
+		t.Errorf("expected unpinned edition to reload build %d, got %d", testBuildEpoch+3600, got)
+	}
+}
+
+func TestPinsFromEnv(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected map[string]uint
+		wantErr  bool
+	}{
+		{value: "", expected: map[string]uint{}},
+		{value: "GeoLite2-City=1700000000", expected: map[string]uint{CityDatabase: 1700000000}},
+		{value: "GeoLite2-City=1, GeoLite2-ASN=2", expected: map[string]uint{CityDatabase: 1, ASNDatabase: 2}},
+		{value: "GeoLite2-City", wantErr: true},
+		{value: "GeoLite2-City=yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv(MaxmindPinnedGenerations, tt.value)
+			got, err := pinsFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
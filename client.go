@@ -1,6 +1,7 @@
 package mmdb
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path"
@@ -24,65 +25,95 @@ func dbPath(dataDir, name string) string {
 	return path.Join(dataDir, name+dbSuffix)
 }
 
+// database is one open edition, swapped under mu on reload.
+type database struct {
+	mu     sync.RWMutex
+	reader *maxminddb.Reader
+}
+
 type Client struct {
 	DataDirectory string
+	Editions      []string
 
-	muCountry sync.RWMutex
-	country   *maxminddb.Reader
-
-	muCity sync.RWMutex
-	city   *maxminddb.Reader
-
-	muASN sync.RWMutex
-	asn   *maxminddb.Reader
+	dbs  map[string]*database
+	pins map[string]uint
 
 	ticker *time.Ticker
 	done   chan struct{}
 }
 
-// NewClient creates a Client and opens all three GeoIP2 databases.
-// If MAXMIND_BASE_PATH is empty, it defaults to the working directory.
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// WithClientEditions sets the editions the Client opens, overriding
+// MAXMIND_EDITION_IDS.
+func WithClientEditions(editions ...string) ClientOption {
+	return func(c *Client) {
+		c.Editions = editions
+	}
+}
+
+// WithDataDirectory sets the directory the Client opens databases from,
+// overriding MAXMIND_BASE_PATH.
+func WithDataDirectory(dir string) ClientOption {
+	return func(c *Client) {
+		c.DataDirectory = dir
+	}
+}
+
+// NewClient creates a Client and opens the configured GeoIP2 databases.
+// If MAXMIND_BASE_PATH is empty, it defaults to the working directory; if
+// MAXMIND_EDITION_IDS is empty, DefaultEditions are opened. Editions
+// not published by MaxMind, such as dbip-city-lite, are opened if their
+// database is in the data directory.
+// MAXMIND_PINNED_GENERATIONS pins editions to a backup, see
+// WithPinnedGeneration.
 // On any error it closes any readers it already opened.
-func NewClient() (*Client, error) {
+func NewClient(opts ...ClientOption) (*Client, error) {
 	dataDirectory := os.Getenv(MaxmindBasePath)
 	if dataDirectory == "" {
 		dataDirectory = "."
 	}
 
-	var (
-		err     error
-		country *maxminddb.Reader
-		city    *maxminddb.Reader
-		asn     *maxminddb.Reader
-	)
-
-	country, err = maxminddb.Open(dbPath(dataDirectory, CountryDatabase))
+	pins, err := pinsFromEnv()
 	if err != nil {
 		return nil, err
 	}
 
-	city, err = maxminddb.Open(dbPath(dataDirectory, CityDatabase))
-	if err != nil {
-		_ = country.Close()
-		return nil, err
+	c := &Client{
+		DataDirectory: dataDirectory,
+		dbs:           make(map[string]*database),
+		pins:          pins,
 	}
-
-	asn, err = maxminddb.Open(dbPath(dataDirectory, ASNDatabase))
-	if err != nil {
-		_ = country.Close()
-		_ = city.Close()
+	for _, opt := range opts {
+		opt(c)
+	}
+	// editions of other publishers are opened if their database is there
+	onDisk := func(e string) bool {
+		_, err := os.Stat(c.path(e))
+		return err == nil
+	}
+	if c.Editions == nil {
+		c.Editions = SplitEditions(os.Getenv(MaxmindEditionIds))
+		if err := validateEditions(c.Editions, onDisk); err != nil {
+			return nil, fmt.Errorf("%s: %w", MaxmindEditionIds, err)
+		}
+	} else if err := validateEditions(c.Editions, onDisk); err != nil {
 		return nil, err
 	}
 
-	c := &Client{
-		DataDirectory: dataDirectory,
-		country:       country,
-		city:          city,
-		asn:           asn,
-		ticker:        time.NewTicker(DefaultReloadInterval),
-		done:          make(chan struct{}),
+	for _, edition := range c.Editions {
+		reader, err := maxminddb.Open(c.path(edition))
+		if err != nil {
+			c.closeReaders()
+			return nil, err
+		}
+		c.dbs[edition] = &database{reader: reader}
 	}
 
+	c.ticker = time.NewTicker(DefaultReloadInterval)
+	c.done = make(chan struct{})
+
 	go c.startReload()
 	return c, nil
 }
@@ -110,49 +141,85 @@ func (c *Client) startReload() {
 
 // reloadAll reloads each DB file in turn.
 func (c *Client) reloadAll() {
-	c.reloadDB(&c.muCountry, &c.country, CountryDatabase)
-	c.reloadDB(&c.muCity, &c.city, CityDatabase)
-	c.reloadDB(&c.muASN, &c.asn, ASNDatabase)
+	for _, edition := range c.Editions {
+		c.reloadDB(edition)
+	}
 }
 
-// reloadDB opens the filename, swaps it in under mu, closes the old reader.
-func (c *Client) reloadDB(mu *sync.RWMutex, ptr **maxminddb.Reader, filename string) {
-	newPath := dbPath(c.DataDirectory, filename)
-	newMM, err := maxminddb.Open(newPath)
+// reloadDB opens the edition's file, swaps it in under its mutex and
+// closes the old reader.
+func (c *Client) reloadDB(edition string) {
+	db, ok := c.dbs[edition]
+	if !ok {
+		return
+	}
+
+	newMM, err := maxminddb.Open(c.path(edition))
 	if err != nil {
-		log.Printf("Failed to open %s (maxminddb): %v", filename, err)
+		log.Printf("Failed to open %s (maxminddb): %v", edition, err)
 		return
 	}
 
-	mu.Lock()
-	old := *ptr
-	*ptr = newMM
-	mu.Unlock()
+	db.mu.Lock()
+	select {
+	case <-c.done:
+		// closed meanwhile
+		db.mu.Unlock()
+		newMM.Close()
+		return
+	default:
+	}
+	old := db.reader
+	db.reader = newMM
+	db.mu.Unlock()
 
 	if err := old.Close(); err != nil {
-		log.Printf("Failed to close old %s (maxminddb): %v", filename, err)
+		log.Printf("Failed to close old %s (maxminddb): %v", edition, err)
+	}
+}
+
+// DatabaseUpdated reloads edition if the Client opened it, so that a Client
+// registered with Downloader.AddListener picks up new databases right away
+// instead of on its next periodic reload.
+func (c *Client) DatabaseUpdated(edition string) {
+	c.reloadDB(edition)
+}
+
+// DB returns the current reader for edition, or nil if the Client was not
+// configured to open it.
+func (c *Client) DB(edition string) *maxminddb.Reader {
+	db, ok := c.dbs[edition]
+	if !ok {
+		return nil
 	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.reader
+}
+
+// dbOfKind returns the reader of the first configured edition of kind.
+func (c *Client) dbOfKind(kind editionKind) *maxminddb.Reader {
+	for _, edition := range c.Editions {
+		if knownEditions[edition] == kind {
+			return c.DB(edition)
+		}
+	}
+	return nil
 }
 
 // CityDB returns the current city database reader.
 func (c *Client) CityDB() *maxminddb.Reader {
-	c.muCity.RLock()
-	defer c.muCity.RUnlock()
-	return c.city
+	return c.dbOfKind(kindCity)
 }
 
 // CountryDB returns the current country database reader.
 func (c *Client) CountryDB() *maxminddb.Reader {
-	c.muCountry.RLock()
-	defer c.muCountry.RUnlock()
-	return c.country
+	return c.dbOfKind(kindCountry)
 }
 
 // AsnDB returns the current ASN database reader.
 func (c *Client) AsnDB() *maxminddb.Reader {
-	c.muASN.RLock()
-	defer c.muASN.RUnlock()
-	return c.asn
+	return c.dbOfKind(kindASN)
 }
 
 // Close stops the reload loop and closes all readers.
@@ -160,17 +227,14 @@ func (c *Client) Close() error {
 	c.ticker.Stop()
 	close(c.done)
 
-	c.muCountry.Lock()
-	_ = c.country.Close()
-	c.muCountry.Unlock()
-
-	c.muCity.Lock()
-	_ = c.city.Close()
-	c.muCity.Unlock()
-
-	c.muASN.Lock()
-	_ = c.asn.Close()
-	c.muASN.Unlock()
-
+	c.closeReaders()
 	return nil
 }
+
+func (c *Client) closeReaders() {
+	for _, db := range c.dbs {
+		db.mu.Lock()
+		_ = db.reader.Close()
+		db.mu.Unlock()
+	}
+}
@@ -0,0 +1,255 @@
+package mmdb
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/NoUmlautsAllowed/go-mmdb/mmdbtest"
+)
+
+func TestNewClient(t *testing.T) {
+	c := newTestClient(t)
+
+	readers := map[string]func() uint{
+		CityDatabase:    func() uint { return c.CityDB().Metadata.BuildEpoch },
+		CountryDatabase: func() uint { return c.CountryDB().Metadata.BuildEpoch },
+		ASNDatabase:     func() uint { return c.AsnDB().Metadata.BuildEpoch },
+	}
+	for name, epoch := range readers {
+		if got := epoch(); got != testBuildEpoch {
+			t.Errorf("%s: expected build epoch %d, got %d", name, testBuildEpoch, got)
+		}
+	}
+
+	if got := c.CityDB().Metadata.DatabaseType; got != CityDatabase {
+		t.Errorf("expected city database type %q, got %q", CityDatabase, got)
+	}
+}
+
+func TestNewClientMissingDatabase(t *testing.T) {
+	tests := []struct {
+		name    string
+		missing string
+	}{
+		{name: "Missing Country", missing: CountryDatabase},
+		{name: "Missing City", missing: CityDatabase},
+		{name: "Missing ASN", missing: ASNDatabase},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestDatabases(t, dir, testBuildEpoch)
+			if err := os.Remove(dbPath(dir, tt.missing)); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv(MaxmindBasePath, dir)
+
+			c, err := NewClient()
+			if err == nil {
+				c.Close()
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestNewClientDefaultDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabases(t, dir, testBuildEpoch)
+	t.Chdir(dir)
+	t.Setenv(MaxmindBasePath, "")
+
+	c, err := NewClient()
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close()
+
+	if c.DataDirectory != "." {
+		t.Errorf("expected data directory %q, got %q", ".", c.DataDirectory)
+	}
+}
+
+func TestNewClientDataDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabases(t, dir, testBuildEpoch)
+	t.Setenv(MaxmindBasePath, t.TempDir())
+
+	c, err := NewClient(WithDataDirectory(dir))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close()
+
+	if c.DataDirectory != dir {
+		t.Errorf("expected data directory %q, got %q", dir, c.DataDirectory)
+	}
+}
+
+func TestClientReload(t *testing.T) {
+	c := newTestClient(t)
+	old := c.CityDB()
+
+	const newEpoch = testBuildEpoch + 86400
+	writeTestDatabases(t, c.DataDirectory, newEpoch)
+	c.reloadAll()
+
+	if c.CityDB() == old {
+		t.Fatal("expected city reader to be swapped")
+	}
+
+	readers := map[string]func() uint{
+		CityDatabase:    func() uint { return c.CityDB().Metadata.BuildEpoch },
+		CountryDatabase: func() uint { return c.CountryDB().Metadata.BuildEpoch },
+		ASNDatabase:     func() uint { return c.AsnDB().Metadata.BuildEpoch },
+	}
+	for name, epoch := range readers {
+		if got := epoch(); got != newEpoch {
+			t.Errorf("%s: expected build epoch %d after reload, got %d", name, newEpoch, got)
+		}
+	}
+
+	info := c.IPInfo(mustParseIP(t, "81.2.69.142"))
+	if info.City != "London" {
+		t.Errorf("expected lookup to work after reload, got city %q", info.City)
+	}
+	if info.CityBuildDate != newEpoch {
+		t.Errorf("expected city build date %d, got %d", newEpoch, info.CityBuildDate)
+	}
+}
+
+func TestClientReloadKeepsReaderOnError(t *testing.T) {
+	c := newTestClient(t)
+	old := c.CityDB()
+
+	// replace the file the way the downloader does, so the mapped old
+	// reader keeps its inode
+	path := dbPath(c.DataDirectory, CityDatabase)
+	if err := os.WriteFile(path+".tmp", []byte("not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		t.Fatal(err)
+	}
+	c.reloadDB(CityDatabase)
+
+	if c.CityDB() != old {
+		t.Fatal("expected city reader to be kept after failed reload")
+	}
+	if info := c.IPInfo(mustParseIP(t, "81.2.69.142")); info.City != "London" {
+		t.Errorf("expected old reader to keep answering, got city %q", info.City)
+	}
+}
+
+func TestNewClientEditions(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabases(t, dir, testBuildEpoch)
+	t.Setenv(MaxmindBasePath, dir)
+	t.Setenv(MaxmindEditionIds, "GeoLite2-City")
+
+	c, err := NewClient()
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close()
+
+	if c.CityDB() == nil {
+		t.Error("expected city database to be opened")
+	}
+	if c.AsnDB() != nil || c.CountryDB() != nil {
+		t.Error("expected only the configured edition to be opened")
+	}
+	info := c.IPInfo(mustParseIP(t, "216.160.83.56"))
+	if info.City != "Milton" || info.ASN != "" {
+		t.Errorf("expected city but no ASN, got %+v", info)
+	}
+
+	c2, err := NewClient(WithClientEditions(ASNDatabase))
+	if err != nil {
+		t.Fatalf("NewClient with editions: %v", err)
+	}
+	defer c2.Close()
+	if c2.CityDB() != nil || c2.DB(ASNDatabase) == nil {
+		t.Error("expected option to override MAXMIND_EDITION_IDS")
+	}
+}
+
+func TestNewClientInvalidEditions(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabases(t, dir, testBuildEpoch)
+	t.Setenv(MaxmindBasePath, dir)
+
+	t.Setenv(MaxmindEditionIds, "GeoLite2-Citty")
+	if c, err := NewClient(); err == nil {
+		c.Close()
+		t.Fatal("expected error for unknown edition in environment")
+	}
+
+	// the variable is not read if an option chose the editions
+	c, err := NewClient(WithClientEditions(ASNDatabase))
+	if err != nil {
+		t.Fatalf("expected option to ignore invalid %s, got %v", MaxmindEditionIds, err)
+	}
+	c.Close()
+
+	t.Setenv(MaxmindEditionIds, "")
+	if c, err := NewClient(WithClientEditions("GeoLite2-Nope")); err == nil {
+		c.Close()
+		t.Fatal("expected error for unknown edition option")
+	}
+}
+
+func TestNewClientRegionalEdition(t *testing.T) {
+	dir := t.TempDir()
+	const edition = "GeoIP2-City-Europe"
+	if err := mmdbtest.WriteDatabase(dbPath(dir, edition), CityDatabase, testBuildEpoch); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(MaxmindBasePath, dir)
+	t.Setenv(MaxmindPinnedGenerations, "")
+
+	// regional City editions answer like GeoIP2-City
+	c, err := NewClient(WithClientEditions(edition))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close()
+	if c.CityDB() == nil {
+		t.Fatalf("expected %s to serve as city database", edition)
+	}
+	if info := c.IPInfo(mustParseIP(t, "81.2.69.142")); info.City != "London" {
+		t.Errorf("expected city from %s, got %+v", edition, info)
+	}
+}
+
+func TestNewClientThirdPartyEdition(t *testing.T) {
+	dir := t.TempDir()
+	const edition = "dbip-city-lite"
+	if err := mmdbtest.WriteDatabase(dbPath(dir, edition), CityDatabase, testBuildEpoch); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(MaxmindBasePath, dir)
+	t.Setenv(MaxmindPinnedGenerations, "")
+
+	// editions of other publishers are opened from the data directory
+	t.Setenv(MaxmindEditionIds, edition)
+	c, err := NewClient()
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close()
+	if c.DB(edition) == nil {
+		t.Errorf("expected %s to be opened", edition)
+	}
+
+	// without a database, an unknown edition is still reported as such
+	t.Setenv(MaxmindEditionIds, "dbip-asn-lite")
+	if c, err := NewClient(); err == nil || !strings.Contains(err.Error(), "unknown edition") {
+		if c != nil {
+			c.Close()
+		}
+		t.Errorf("expected unknown edition without a database, got %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/NoUmlautsAllowed/go-mmdb"
+)
+
+// download downloads new releases of the editions given as arguments,
+// defaulting to the configured ones. With -dry-run it only prints their
+// status.
+func (c *cli) download(ctx context.Context, args []string) error {
+	if c.dryRun {
+		return c.status(ctx, args)
+	}
+	d, err := c.downloader(mmdb.WithProgress(newProgressPrinter().update))
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		if err := d.ValidateEditions(args); err != nil {
+			return usageError(err.Error())
+		}
+	}
+	report, err := d.DownloadDatabases(ctx, args...)
+	if report != nil {
+		fmt.Print(report)
+	}
+	return err
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NoUmlautsAllowed/go-mmdb"
+)
+
+const defaultEnrichFields = "country_code,city,asn"
+
+// enricher adds the IP info of the client address to log lines.
+type enricher struct {
+	lookup lookupFunc
+	fields []lookupField
+	prefix string
+	// ipField is the path of the address in JSON lines, split at dots.
+	ipField []string
+	json    bool
+	workers int
+	ordered bool
+}
+
+// enrich returns line with the fields appended and whether the line had a
+// valid address. Lines without one are returned unchanged.
+func (e *enricher) enrich(line []byte) ([]byte, bool) {
+	if e.json {
+		return e.enrichJSON(line)
+	}
+
+	// the combined and common log formats start with the client address
+	host, _, _ := bytes.Cut(line, []byte(" "))
+	info, ok := e.lookup.resolve(string(host))
+	if !ok {
+		return line, false
+	}
+	out := make([]byte, 0, len(line)+64)
+	out = append(out, line...)
+	for _, f := range e.fields {
+		out = append(out, ' ')
+		out = append(out, e.prefix...)
+		out = append(out, f.name...)
+		out = append(out, '=')
+		out = strconv.AppendQuote(out, f.text(info))
+	}
+	return out, true
+}
+
+func (e *enricher) enrichJSON(line []byte) ([]byte, bool) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(line, &obj); err != nil {
+		return line, false
+	}
+	raw, ok := obj[e.ipField[0]]
+	for _, key := range e.ipField[1:] {
+		var nested map[string]json.RawMessage
+		if !ok || json.Unmarshal(raw, &nested) != nil {
+			return line, false
+		}
+		raw, ok = nested[key]
+	}
+	var host string
+	if !ok || json.Unmarshal(raw, &host) != nil {
+		return line, false
+	}
+	info, ok := e.lookup.resolve(host)
+	if !ok {
+		return line, false
+	}
+
+	// append to the object as is, keeping its keys and their order
+	line = bytes.TrimRight(line, " \t\r")
+	out := make([]byte, 0, len(line)+64)
+	out = append(out, line[:len(line)-1]...)
+	for i, f := range e.fields {
+		if i > 0 || len(obj) > 0 {
+			out = append(out, ',')
+		}
+		key, _ := json.Marshal(e.prefix + f.name)
+		value, err := json.Marshal(f.value(info))
+		if err != nil {
+			return line, false
+		}
+		out = append(out, key...)
+		out = append(out, ':')
+		out = append(out, value...)
+	}
+	return append(out, '}'), true
+}
+
+type enrichResult struct {
+	line     []byte
+	enriched bool
+}
+
+type enrichJob struct {
+	line   []byte
+	result chan enrichResult
+}
+
+// enrichStats counts the lines of an enrich run.
+type enrichStats struct {
+	start    time.Time
+	lines    int
+	enriched int
+	skipped  int
+}
+
+func (s *enrichStats) String() string {
+	elapsed := time.Since(s.start)
+	return fmt.Sprintf("%d lines, %d enriched, %d skipped in %s (%.0f lines/s)",
+		s.lines, s.enriched, s.skipped, elapsed.Round(time.Millisecond), float64(s.lines)/elapsed.Seconds())
+}
+
+// enrich reads access logs from the files given as arguments or stdin and
+// writes them to stdout with the IP info of the client address appended:
+// as key="value" pairs to lines in the combined or common log format, as
+// keys to JSON lines. Lines without a valid address are written unchanged.
+//
+// The lookups run on a pool of workers, so lines are written in the order
+// they are done unless -ordered is set. Output is flushed whenever the
+// workers wait for input, so that it also works on followed logs.
+func (c *cli) enrich(ctx context.Context, args []string) error {
+	fields, err := parseFields(c.enrichFields)
+	if err != nil {
+		return usageError(err.Error())
+	}
+	e := &enricher{fields: fields, prefix: c.prefix, ipField: strings.Split(c.ipField, "."), workers: c.workers, ordered: c.ordered}
+	switch c.logFormat {
+	case "combined":
+	case "json":
+		e.json = true
+	default:
+		return usageError(fmt.Sprintf("unknown log format %q, expected combined or json", c.logFormat))
+	}
+	if c.workers < 1 {
+		return usageError("-workers must be at least 1")
+	}
+
+	var in io.Reader = os.Stdin
+	if len(args) > 0 && !(len(args) == 1 && args[0] == "-") {
+		readers := make([]io.Reader, len(args))
+		for i, name := range args {
+			f, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			readers[i] = f
+		}
+		in = io.MultiReader(readers...)
+	}
+
+	opts, err := c.clientOptions()
+	if err != nil {
+		return err
+	}
+	client, err := mmdb.NewClient(opts...)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	e.lookup = client.IPInfo
+
+	stats := &enrichStats{start: time.Now()}
+	var tick <-chan time.Time
+	if c.statsInterval > 0 {
+		ticker := time.NewTicker(c.statsInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	defer func() {
+		if c.stats || c.statsInterval > 0 {
+			fmt.Fprintf(os.Stderr, "enrich: %s\n", stats)
+		}
+	}()
+	return e.run(ctx, in, os.Stdout, stats, tick)
+}
+
+// run enriches the lines of in and writes them to out, printing stats on
+// every tick. It stops at the first error reading or writing.
+func (e *enricher) run(ctx context.Context, in io.Reader, out io.Writer, stats *enrichStats, tick <-chan time.Time) error {
+	// stops the reader and workers when returning early
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// pending receives the result channel of each line in the order of
+	// the output: when read if ordered, when done otherwise
+	jobs := make(chan enrichJob, e.workers)
+	pending := make(chan chan enrichResult, 64*e.workers)
+	var readErr error
+	go func() {
+		defer close(jobs)
+		if e.ordered {
+			defer close(pending)
+		}
+		sc := bufio.NewScanner(in)
+		sc.Buffer(make([]byte, 64<<10), 1<<20)
+		for sc.Scan() {
+			job := enrichJob{line: bytes.Clone(sc.Bytes()), result: make(chan enrichResult, 1)}
+			if e.ordered {
+				select {
+				case pending <- job.result:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+		readErr = sc.Err()
+	}()
+
+	var wg sync.WaitGroup
+	for range e.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				line, ok := e.enrich(job.line)
+				job.result <- enrichResult{line: line, enriched: ok}
+				if !e.ordered {
+					select {
+					case pending <- job.result:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+	}
+	if !e.ordered {
+		go func() {
+			wg.Wait()
+			close(pending)
+		}()
+	}
+
+	w := bufio.NewWriterSize(out, 64<<10)
+	for {
+		var result chan enrichResult
+		var ok bool
+		select {
+		case result, ok = <-pending:
+		case <-tick:
+			fmt.Fprintf(os.Stderr, "enrich: %s\n", stats)
+			continue
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// nothing done yet, so flush what is written while waiting
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			select {
+			case result, ok = <-pending:
+			case <-tick:
+				fmt.Fprintf(os.Stderr, "enrich: %s\n", stats)
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if !ok {
+			break
+		}
+
+		r := <-result
+		stats.lines++
+		if r.enriched {
+			stats.enriched++
+		} else {
+			stats.skipped++
+		}
+		if _, err := w.Write(r.line); err != nil {
+			return err
+		}
+		if err := w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return readErr
+}
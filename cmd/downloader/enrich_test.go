@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NoUmlautsAllowed/go-mmdb"
+)
+
+// fakeLookup returns GB and a fixed AS for every address.
+func fakeLookup(ip net.IP) mmdb.IPInfo {
+	return mmdb.IPInfo{IP: ip, CountryCode: "GB", City: "London", ASN: "AS64500 Example"}
+}
+
+func TestEnrich(t *testing.T) {
+	fields, err := parseFields(defaultEnrichFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		json     bool
+		prefix   string
+		ipField  string
+		line     string
+		want     string
+		enriched bool
+	}{
+		{
+			name:     "combined",
+			line:     `81.2.69.142 - - [10/Oct/2024:13:55:36 +0000] "GET / HTTP/1.1" 200 2326 "-" "curl/8.0"`,
+			want:     `81.2.69.142 - - [10/Oct/2024:13:55:36 +0000] "GET / HTTP/1.1" 200 2326 "-" "curl/8.0" country_code="GB" city="London" asn="AS64500 Example"`,
+			enriched: true,
+		},
+		{
+			name:     "combined with prefix and port",
+			prefix:   "geo_",
+			line:     `[2001:db8::1]:443 - - "GET / HTTP/1.1" 200`,
+			want:     `[2001:db8::1]:443 - - "GET / HTTP/1.1" 200 geo_country_code="GB" geo_city="London" geo_asn="AS64500 Example"`,
+			enriched: true,
+		},
+		{
+			name: "combined unspecified address",
+			line: `:: - - "GET / HTTP/1.1" 200`,
+			want: `:: - - "GET / HTTP/1.1" 200`,
+		},
+		{
+			name: "combined without address",
+			line: `localhost - - "GET / HTTP/1.1" 200`,
+			want: `localhost - - "GET / HTTP/1.1" 200`,
+		},
+		{
+			name:     "json",
+			json:     true,
+			ipField:  "remote_addr",
+			line:     `{"status":200,"remote_addr":"81.2.69.142"}`,
+			want:     `{"status":200,"remote_addr":"81.2.69.142","country_code":"GB","city":"London","asn":"AS64500 Example"}`,
+			enriched: true,
+		},
+		{
+			name:     "json nested field",
+			json:     true,
+			prefix:   "geo_",
+			ipField:  "request.client",
+			line:     `{"request":{"client":"81.2.69.142:51234"}}  `,
+			want:     `{"request":{"client":"81.2.69.142:51234"},"geo_country_code":"GB","geo_city":"London","geo_asn":"AS64500 Example"}`,
+			enriched: true,
+		},
+		{
+			name:    "json missing field",
+			json:    true,
+			ipField: "remote_addr",
+			line:    `{"status":200}`,
+			want:    `{"status":200}`,
+		},
+		{
+			name:    "json invalid address",
+			json:    true,
+			ipField: "remote_addr",
+			line:    `{"remote_addr":"unknown"}`,
+			want:    `{"remote_addr":"unknown"}`,
+		},
+		{
+			name:    "json invalid line",
+			json:    true,
+			ipField: "remote_addr",
+			line:    `81.2.69.142 - -`,
+			want:    `81.2.69.142 - -`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &enricher{lookup: fakeLookup, fields: fields, prefix: tt.prefix, ipField: strings.Split(tt.ipField, "."), json: tt.json}
+			got, ok := e.enrich([]byte(tt.line))
+			if string(got) != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+			if ok != tt.enriched {
+				t.Errorf("expected enriched %v, got %v", tt.enriched, ok)
+			}
+		})
+	}
+}
+
+func TestEnrichRunOrdered(t *testing.T) {
+	fields, err := parseFields("country_code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// later lines are looked up faster, so the workers finish them first
+	const n = 40
+	lookup := func(ip net.IP) mmdb.IPInfo {
+		time.Sleep(time.Duration(n-int(ip.To4()[3])) * 100 * time.Microsecond)
+		return fakeLookup(ip)
+	}
+
+	var in strings.Builder
+	var want []string
+	for i := range n {
+		fmt.Fprintf(&in, "10.0.0.%d - - line %d\n", i, i)
+		want = append(want, fmt.Sprintf(`10.0.0.%d - - line %d country_code="GB"`, i, i))
+	}
+	want = append(want, "not a log line")
+	in.WriteString("not a log line\n")
+
+	for _, ordered := range []bool{true, false} {
+		t.Run(fmt.Sprintf("ordered=%v", ordered), func(t *testing.T) {
+			e := &enricher{lookup: lookup, fields: fields, workers: 8, ordered: ordered}
+			var out bytes.Buffer
+			stats := &enrichStats{start: time.Now()}
+			if err := e.run(context.Background(), strings.NewReader(in.String()), &out, stats, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			if !ordered {
+				slices.Sort(got)
+				want := slices.Clone(want)
+				slices.Sort(want)
+				if !slices.Equal(got, want) {
+					t.Errorf("expected lines %q, got %q", want, got)
+				}
+			} else if !slices.Equal(got, want) {
+				t.Errorf("expected lines %q, got %q", want, got)
+			}
+			if stats.lines != n+1 || stats.enriched != n || stats.skipped != 1 {
+				t.Errorf("expected %d lines, %d enriched, 1 skipped, got %s", n+1, n, stats)
+			}
+		})
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestEnrichRunWriteError(t *testing.T) {
+	fields, err := parseFields("country_code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// longer than the write buffer, so writing fails before the end
+	in := strings.Repeat("81.2.69.142 - - "+strings.Repeat("x", 1000)+"\n", 200)
+
+	for _, ordered := range []bool{true, false} {
+		e := &enricher{lookup: fakeLookup, fields: fields, workers: 2, ordered: ordered}
+		err := e.run(context.Background(), strings.NewReader(in), failingWriter{}, &enrichStats{start: time.Now()}, nil)
+		if err == nil || err.Error() != "broken pipe" {
+			t.Errorf("expected the write error, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/NoUmlautsAllowed/go-mmdb"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// inspection is the result of inspecting a database file.
+type inspection struct {
+	Path string `json:"path"`
+	*mmdb.DatabaseInfo
+}
+
+// inspect prints the metadata and network counts of the databases given as
+// editions or files, defaulting to the configured editions.
+func (c *cli) inspect(_ context.Context, args []string) error {
+	if len(args) == 0 {
+		editions, err := c.editionList(nil)
+		if err != nil {
+			return usageError(err.Error())
+		}
+		args = editions
+	}
+	paths := make([]string, len(args))
+	for i, arg := range args {
+		if isDatabaseFile(arg) {
+			paths[i] = arg
+			continue
+		}
+		if _, err := c.editionList([]string{arg}); err != nil {
+			return usageError(err.Error())
+		}
+		paths[i] = filepath.Join(c.dataDir(), arg+".mmdb")
+	}
+
+	var errs []error
+	enc := json.NewEncoder(os.Stdout)
+	for i, path := range paths {
+		info, err := inspectDatabase(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		if c.jsonOutput {
+			if err := enc.Encode(inspection{Path: path, DatabaseInfo: info}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if i > 0 {
+			fmt.Println()
+		}
+		printDatabaseInfo(path, info)
+	}
+	return errors.Join(errs...)
+}
+
+// isDatabaseFile reports whether arg names a file rather than an edition.
+func isDatabaseFile(arg string) bool {
+	return strings.HasSuffix(arg, ".mmdb") || strings.ContainsRune(arg, filepath.Separator)
+}
+
+func inspectDatabase(path string) (*mmdb.DatabaseInfo, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return mmdb.InspectDatabase(reader)
+}
+
+func printDatabaseInfo(path string, info *mmdb.DatabaseInfo) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "File:\t%s\n", path)
+	fmt.Fprintf(tw, "Type:\t%s\n", info.DatabaseType)
+	for _, lang := range slices.Sorted(maps.Keys(info.Description)) {
+		fmt.Fprintf(tw, "Description (%s):\t%s\n", lang, info.Description[lang])
+	}
+	fmt.Fprintf(tw, "Build:\t%s (%d)\n", info.BuildTime.Format(time.RFC3339), info.BuildEpoch)
+	fmt.Fprintf(tw, "Binary format:\t%s\n", info.BinaryFormat)
+	fmt.Fprintf(tw, "IP version:\t%d\n", info.IPVersion)
+	fmt.Fprintf(tw, "Record size:\t%d bits\n", info.RecordSize)
+	fmt.Fprintf(tw, "Nodes:\t%d (%d bytes)\n", info.NodeCount, info.TreeSize)
+	fmt.Fprintf(tw, "Languages:\t%s\n", strings.Join(info.Languages, ", "))
+	fmt.Fprintf(tw, "IPv4 networks:\t%d\n", info.IPv4Networks)
+	fmt.Fprintf(tw, "IPv6 networks:\t%d\n", info.IPv6Networks)
+	tw.Flush()
+}
+
+// diff prints the networks added, removed or changed from the database
+// file OLD to NEW, followed by the country and ASN reassignments.
+func (c *cli) diff(_ context.Context, args []string) error {
+	if len(args) != 2 {
+		return usageError("expected OLD and NEW database files")
+	}
+	if c.top < 0 {
+		return usageError("-top must not be negative")
+	}
+
+	readers := make([]*maxminddb.Reader, len(args))
+	for i, path := range args {
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		readers[i] = reader
+	}
+	if old, new := readers[0].Metadata.DatabaseType, readers[1].Metadata.DatabaseType; old != new {
+		fmt.Fprintf(os.Stderr, "downloader diff: comparing %s to %s\n", old, new)
+	}
+
+	// text output prints the changes as they are found, only JSON output
+	// collects them
+	out := bufio.NewWriter(os.Stdout)
+	var diff *mmdb.DatabaseDiff
+	var err error
+	switch {
+	case c.summaryOnly:
+		diff, err = mmdb.DiffDatabasesFunc(readers[0], readers[1], nil)
+	case c.jsonOutput:
+		diff, err = mmdb.DiffDatabases(readers[0], readers[1])
+	default:
+		diff, err = mmdb.DiffDatabasesFunc(readers[0], readers[1], func(change mmdb.NetworkChange) error {
+			return printChange(out, change)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	diff.Countries = topReassignments(diff.Countries, c.top)
+	diff.ASNs = topReassignments(diff.ASNs, c.top)
+
+	if c.jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if !c.summaryOnly && diff.Added+diff.Removed+diff.Changed > 0 {
+		fmt.Fprintln(tw)
+	}
+	fmt.Fprintf(tw, "%d added, %d removed, %d changed networks\n", diff.Added, diff.Removed, diff.Changed)
+	for _, r := range []struct {
+		title string
+		list  []mmdb.Reassignment
+	}{{"Country reassignments", diff.Countries}, {"ASN reassignments", diff.ASNs}} {
+		if len(r.list) == 0 {
+			continue
+		}
+		fmt.Fprintf(tw, "\n%s:\n", r.title)
+		for _, ra := range r.list {
+			fmt.Fprintf(tw, "  %s -> %s\t%d\n", orDash(ra.From), orDash(ra.To), ra.Networks)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// printChange prints a change of diff as a line with a fixed-width network
+// column, as the changes are not known in advance to align them.
+func printChange(w io.Writer, change mmdb.NetworkChange) error {
+	var err error
+	switch change.Kind {
+	case mmdb.NetworkAdded:
+		_, err = fmt.Fprintf(w, "+  %-18s  %s\n", change.Network, recordString(change.New))
+	case mmdb.NetworkRemoved:
+		_, err = fmt.Fprintf(w, "-  %-18s  %s\n", change.Network, recordString(change.Old))
+	default:
+		_, err = fmt.Fprintf(w, "~  %-18s  %s -> %s\n", change.Network, recordString(change.Old), recordString(change.New))
+	}
+	return err
+}
+
+// topReassignments returns the first n of list, all if n is 0.
+func topReassignments(list []mmdb.Reassignment, n int) []mmdb.Reassignment {
+	if n > 0 && len(list) > n {
+		return list[:n]
+	}
+	return list
+}
+
+func recordString(rec *mmdb.NetworkRecord) string {
+	var parts []string
+	if rec.CountryCode != "" {
+		parts = append(parts, rec.CountryCode)
+	}
+	if rec.ASN != 0 {
+		parts = append(parts, strings.TrimSpace(fmt.Sprintf("AS%d %s", rec.ASN, rec.Organization)))
+	}
+	return orDash(strings.Join(parts, " "))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
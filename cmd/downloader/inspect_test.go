@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/NoUmlautsAllowed/go-mmdb"
+)
+
+func TestPrintChange(t *testing.T) {
+	gb := &mmdb.NetworkRecord{CountryCode: "GB"}
+	fr := &mmdb.NetworkRecord{CountryCode: "FR", ASN: 64500, Organization: "Example"}
+	tests := []struct {
+		change   mmdb.NetworkChange
+		expected string
+	}{
+		{mmdb.NetworkChange{Kind: mmdb.NetworkAdded, Network: "5.0.0.0/16", New: gb}, "+  5.0.0.0/16          " + recordString(gb) + "\n"},
+		{mmdb.NetworkChange{Kind: mmdb.NetworkRemoved, Network: "2001:218::/32", Old: fr}, "-  2001:218::/32       " + recordString(fr) + "\n"},
+		{mmdb.NetworkChange{Kind: mmdb.NetworkChanged, Network: "81.2.69.128/25", Old: gb, New: fr}, "~  81.2.69.128/25      " + recordString(gb) + " -> " + recordString(fr) + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.change.Kind, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := printChange(&buf, tt.change); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/NoUmlautsAllowed/go-mmdb"
+)
+
+// lookupField is a column of the lookup output, named like the JSON field
+// of mmdb.IPInfo.
+type lookupField struct {
+	name  string
+	value func(mmdb.IPInfo) any
+	// width is the column width of streamed text output.
+	width int
+}
+
+var lookupFields = []lookupField{
+	{"ip", func(i mmdb.IPInfo) any { return i.IP.String() }, 15},
+	{"ip_type", func(i mmdb.IPInfo) any { return i.IPType }, 7},
+	{"network", func(i mmdb.IPInfo) any { return i.Network }, 18},
+	{"country_code", func(i mmdb.IPInfo) any { return i.CountryCode }, 12},
+	{"city", func(i mmdb.IPInfo) any { return i.City }, 20},
+	{"asn", func(i mmdb.IPInfo) any { return i.ASN }, 30},
+	{"city_build_date", func(i mmdb.IPInfo) any { return i.CityBuildDate }, 15},
+	{"asn_build_date", func(i mmdb.IPInfo) any { return i.ASNBuildDate }, 14},
+}
+
+// text returns the value of the field formatted for text, CSV and TSV.
+func (f lookupField) text(info mmdb.IPInfo) string {
+	switch v := f.value(info).(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// lookupFunc returns the IP info of an address, e.g. Client.IPInfo. lookup
+// and enrich resolve every address through it.
+type lookupFunc func(net.IP) mmdb.IPInfo
+
+// resolve looks up s, an IP address optionally with a port, and reports
+// whether it was valid. The unspecified addresses :: and 0.0.0.0 are not.
+func (f lookupFunc) resolve(s string) (mmdb.IPInfo, bool) {
+	ip := parseHost(s)
+	if ip == nil || ip.IsUnspecified() {
+		return mmdb.IPInfo{}, false
+	}
+	return f(ip), true
+}
+
+// parseHost parses an IP address, optionally with a port.
+func parseHost(s string) net.IP {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return net.ParseIP(host)
+	}
+	return nil
+}
+
+const defaultLookupFields = "ip,network,country_code,city,asn"
+
+// addressPattern matches IPv4 and IPv6 address candidates in log lines;
+// they are validated by parsing.
+var addressPattern = regexp.MustCompile(`(?:\d{1,3}\.){3}\d{1,3}|[0-9A-Fa-f]*:[0-9A-Fa-f]*:[0-9A-Fa-f:.]*`)
+
+func parseFields(s string) ([]lookupField, error) {
+	var fields []lookupField
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		i := -1
+		for j, f := range lookupFields {
+			if f.name == name {
+				i = j
+			}
+		}
+		if i < 0 {
+			names := make([]string, len(lookupFields))
+			for j, f := range lookupFields {
+				names[j] = f.name
+			}
+			return nil, fmt.Errorf("unknown field %q, expected one of %s", name, strings.Join(names, ", "))
+		}
+		fields = append(fields, lookupFields[i])
+	}
+	return fields, nil
+}
+
+// lookup prints the IP info of the addresses given as arguments or, without
+// arguments or with "-", read from stdin one per line. With -extract or
+// -regex the addresses are taken from anywhere in the lines instead, e.g.
+// from access logs. Invalid addresses are reported and make the command
+// fail after the others are printed.
+func (c *cli) lookup(_ context.Context, args []string) error {
+	format := c.format
+	if c.jsonOutput {
+		format = "json"
+	}
+	fields, err := parseFields(c.fields)
+	if err != nil {
+		return usageError(err.Error())
+	}
+	// from stdin, e.g. a followed log, every line is printed right away
+	stdin := len(args) == 0 || (len(args) == 1 && args[0] == "-")
+	out, err := newLookupWriter(os.Stdout, format, fields, !c.noHeader, stdin)
+	if err != nil {
+		return usageError(err.Error())
+	}
+	pattern := addressPattern
+	if c.regex != "" {
+		if pattern, err = regexp.Compile(c.regex); err != nil {
+			return usageError(fmt.Sprintf("invalid -regex: %v", err))
+		}
+	}
+	extract := c.extract || c.regex != ""
+
+	opts, err := c.clientOptions()
+	if err != nil {
+		return err
+	}
+	client, err := mmdb.NewClient(opts...)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	lookup := lookupFunc(client.IPInfo)
+	invalid := 0
+	resolve := func(s string) error {
+		info, ok := lookup.resolve(s)
+		if !ok {
+			invalid++
+			fmt.Fprintf(os.Stderr, "downloader lookup: invalid IP address %q\n", s)
+			return nil
+		}
+		return out.write(info)
+	}
+
+	if !stdin {
+		for _, arg := range args {
+			if err := resolve(arg); err != nil {
+				return err
+			}
+		}
+	} else {
+		sc := bufio.NewScanner(os.Stdin)
+		sc.Buffer(make([]byte, 64<<10), 1<<20)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if !extract {
+				if line != "" && !strings.HasPrefix(line, "#") {
+					if err := resolve(line); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			for _, s := range extractAddresses(pattern, line) {
+				if err := resolve(s); err != nil {
+					return err
+				}
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return err
+		}
+	}
+
+	if err := out.flush(); err != nil {
+		return err
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d invalid IP addresses", invalid)
+	}
+	return nil
+}
+
+// extractAddresses returns the valid IP addresses pattern matches in line,
+// using the first capturing group if it has one. Matches that are no
+// address, e.g. times matched as IPv6 candidates, matches within words and
+// the unspecified addresses are skipped.
+func extractAddresses(pattern *regexp.Regexp, line string) []string {
+	var addrs []string
+	for _, m := range pattern.FindAllStringSubmatchIndex(line, -1) {
+		s := line[m[0]:m[1]]
+		if len(m) > 2 {
+			if m[2] < 0 {
+				continue
+			}
+			s = line[m[2]:m[3]]
+		} else if (m[0] > 0 && isWordByte(line[m[0]-1])) || (m[1] < len(line) && isWordByte(line[m[1]])) {
+			// part of a word, e.g. "d::" and "::f" in "std::foo"
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			// a trailing separator, e.g. in "2001:db8::1: message"
+			s = strings.TrimRight(s, ":.")
+			ip = net.ParseIP(s)
+		}
+		if ip == nil || ip.IsUnspecified() {
+			continue
+		}
+		addrs = append(addrs, s)
+	}
+	return addrs
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+// lookupWriter prints IP info as an aligned table, JSON lines, CSV or TSV.
+// When streaming, every row is written at once, the table with fixed
+// column widths.
+type lookupWriter struct {
+	format string
+	fields []lookupField
+	stream bool
+	w      io.Writer
+
+	tw  *tabwriter.Writer
+	csv *csv.Writer
+}
+
+func newLookupWriter(w io.Writer, format string, fields []lookupField, header, stream bool) (*lookupWriter, error) {
+	lw := &lookupWriter{format: format, fields: fields, stream: stream, w: w}
+	switch format {
+	case "text":
+		if !stream {
+			lw.tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		}
+	case "csv", "tsv":
+		lw.csv = csv.NewWriter(w)
+		if format == "tsv" {
+			lw.csv.Comma = '\t'
+		}
+	case "json":
+		return lw, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected text, json, csv or tsv", format)
+	}
+
+	if header {
+		names := make([]string, len(fields))
+		for i, f := range fields {
+			names[i] = f.name
+			if format == "text" {
+				names[i] = strings.ToUpper(f.name)
+			}
+		}
+		if err := lw.row(names); err != nil {
+			return nil, err
+		}
+	}
+	return lw, nil
+}
+
+func (lw *lookupWriter) write(info mmdb.IPInfo) error {
+	if lw.format == "json" {
+		// keep the selected order, which a map would not
+		var b strings.Builder
+		b.WriteByte('{')
+		for i, f := range lw.fields {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			key, _ := json.Marshal(f.name)
+			value, err := json.Marshal(f.value(info))
+			if err != nil {
+				return err
+			}
+			b.Write(key)
+			b.WriteByte(':')
+			b.Write(value)
+		}
+		b.WriteString("}\n")
+		_, err := io.WriteString(lw.w, b.String())
+		return err
+	}
+
+	values := make([]string, len(lw.fields))
+	for i, f := range lw.fields {
+		values[i] = f.text(info)
+	}
+	return lw.row(values)
+}
+
+func (lw *lookupWriter) row(values []string) error {
+	switch {
+	case lw.csv != nil:
+		if err := lw.csv.Write(values); err != nil {
+			return err
+		}
+		if lw.stream {
+			lw.csv.Flush()
+			return lw.csv.Error()
+		}
+		return nil
+	case lw.tw != nil:
+		_, err := fmt.Fprintln(lw.tw, strings.Join(values, "\t"))
+		return err
+	}
+
+	var b strings.Builder
+	for i, v := range values {
+		if i == len(values)-1 {
+			b.WriteString(v)
+			break
+		}
+		fmt.Fprintf(&b, "%-*s  ", lw.fields[i].width, v)
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(lw.w, b.String())
+	return err
+}
+
+func (lw *lookupWriter) flush() error {
+	switch {
+	case lw.tw != nil:
+		return lw.tw.Flush()
+	case lw.csv != nil:
+		lw.csv.Flush()
+		return lw.csv.Error()
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/NoUmlautsAllowed/go-mmdb"
+)
+
+func TestExtractAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern *regexp.Regexp
+		line    string
+		want    []string
+	}{
+		{"combined log", addressPattern, `81.2.69.142 - - [10/Oct/2024:13:55:36 +0000] "GET / HTTP/1.1" 200 2326`, []string{"81.2.69.142"}},
+		{"ipv6", addressPattern, `2001:db8::1 - - "GET / HTTP/1.1" 200`, []string{"2001:db8::1"}},
+		{"trailing colon", addressPattern, "connection from 2001:db8::1: reset", []string{"2001:db8::1"}},
+		{"several", addressPattern, "from 10.0.0.1 to 192.0.2.7", []string{"10.0.0.1", "192.0.2.7"}},
+		{"no address", addressPattern, "12:30:45 999.1.1.1 started", nil},
+		{"unspecified", addressPattern, "C++::foo std::string :: 0.0.0.0 [::1]:80", []string{"::1"}},
+		{"capturing group", regexp.MustCompile(`client=(\S+)`), "server=10.0.0.1 client=192.0.2.7", []string{"192.0.2.7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractAddresses(tt.pattern, tt.line)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLookupWriter(t *testing.T) {
+	fields, err := parseFields("ip,country_code,asn,city_build_date")
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos := []mmdb.IPInfo{
+		{IP: net.ParseIP("81.2.69.142"), CountryCode: "GB", ASN: "AS1 Example, Inc.", CityBuildDate: 1700000000},
+		{IP: net.ParseIP("2001:db8::1")},
+	}
+
+	tests := []struct {
+		format string
+		stream bool
+		want   string
+	}{
+		{"text", false, "IP           COUNTRY_CODE  ASN                CITY_BUILD_DATE\n" +
+			"81.2.69.142  GB            AS1 Example, Inc.  1700000000\n" +
+			"2001:db8::1                                   0\n"},
+		{"text", true, "IP               COUNTRY_CODE  ASN                             CITY_BUILD_DATE\n" +
+			"81.2.69.142      GB            AS1 Example, Inc.               1700000000\n" +
+			"2001:db8::1                                                    0\n"},
+		{"json", false, `{"ip":"81.2.69.142","country_code":"GB","asn":"AS1 Example, Inc.","city_build_date":1700000000}` + "\n" +
+			`{"ip":"2001:db8::1","country_code":"","asn":"","city_build_date":0}` + "\n"},
+		{"csv", false, "ip,country_code,asn,city_build_date\n" +
+			"81.2.69.142,GB,\"AS1 Example, Inc.\",1700000000\n" +
+			"2001:db8::1,,,0\n"},
+		{"tsv", true, "ip\tcountry_code\tasn\tcity_build_date\n" +
+			"81.2.69.142\tGB\tAS1 Example, Inc.\t1700000000\n" +
+			"2001:db8::1\t\t\t0\n"},
+	}
+	for _, tt := range tests {
+		name := tt.format
+		if tt.stream {
+			name += " stream"
+		}
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			lw, err := newLookupWriter(&buf, tt.format, fields, true, tt.stream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, info := range infos {
+				if err := lw.write(info); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := lw.flush(); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("expected\n%s\ngot\n%s", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := newLookupWriter(&bytes.Buffer{}, "xml", fields, true, false); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+// TestLookupWriterStream checks that streamed rows are written before the
+// writer is flushed, as they are for followed logs.
+func TestLookupWriterStream(t *testing.T) {
+	fields, err := parseFields(defaultLookupFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{"text", "json", "csv", "tsv"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			lw, err := newLookupWriter(&buf, format, fields, false, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := lw.write(mmdb.IPInfo{IP: net.ParseIP("81.2.69.142"), CountryCode: "GB"}); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(buf.Bytes(), []byte("81.2.69.142")) || !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+				t.Errorf("expected the row written before flush, got %q", buf.String())
+			}
+		})
+	}
+}
@@ -1,28 +1,407 @@
+// Command downloader downloads MaxMind GeoIP2 databases and manages them on
+// disk. Without a command it downloads, so that it can run from cron or as
+// a container entry point; it also accepts geoipupdate's -f and -d flags.
+//
+// Flags override the environment, which is read from .env if present.
+// The exit code is 0 on success, 1 if the command failed and 2 for usage
+// errors.
 package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
 
 	"github.com/NoUmlautsAllowed/go-mmdb"
 	"github.com/joho/godotenv"
+)
 
-	"log"
+const (
+	exitFailure = 1
+	exitUsage   = 2
 )
 
+// usageError is returned by commands for invalid arguments.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// command is a subcommand of the downloader.
+type command struct {
+	name    string
+	args    string
+	summary string
+	// setup registers the flags of the command besides the common ones.
+	setup func(fs *flag.FlagSet)
+	run   func(ctx context.Context, args []string) error
+
+	flags *flag.FlagSet
+}
+
+// cli holds the flag values shared by the commands.
+type cli struct {
+	envFile  string
+	confFile string
+	dir      string
+	editions string
+
+	dryRun     bool
+	jsonOutput bool
+	format     string
+	fields     string
+	extract    bool
+	regex      string
+	noHeader   bool
+
+	logFormat     string
+	ipField       string
+	enrichFields  string
+	prefix        string
+	workers       int
+	ordered       bool
+	stats         bool
+	statsInterval time.Duration
+
+	summaryOnly bool
+	top         int
+
+	addr        string
+	metricsAddr string
+
+	conf *mmdb.GeoIPConf
+}
+
 func main() {
+	os.Exit(run(os.Args[1:], os.Stderr))
+}
+
+func run(args []string, stderr io.Writer) int {
+	c := &cli{}
+	cmds := c.commands()
 
-	if err := godotenv.Load(); err != nil {
-		log.Fatal(err)
+	// flags before a command select the default command, download
+	root := c.flagSet("downloader", stderr, cmds[0].setup)
+	root.Usage = func() {
+		printUsage(stderr, cmds)
+		fmt.Fprintf(stderr, "\nFlags:\n")
+		root.PrintDefaults()
+	}
+	for _, cmd := range cmds {
+		cmd.flags = c.flagSet("downloader "+cmd.name, stderr, cmd.setup)
+		cmd.flags.Usage = func() {
+			fmt.Fprintf(stderr, "usage: downloader %s [flags] %s\n\n%s.\n\nFlags:\n", cmd.name, cmd.args, cmd.summary)
+			cmd.flags.PrintDefaults()
+		}
 	}
 
-	d, err := mmdb.NewDownloader()
+	if err := root.Parse(args); err != nil {
+		return parseExit(err)
+	}
+	cmd, cmdArgs := cmds[0], root.Args()
+	if len(cmdArgs) > 0 {
+		name := cmdArgs[0]
+		if name == "help" {
+			return help(stderr, cmds, cmdArgs[1:])
+		}
+		cmd = lookupCommand(cmds, name)
+		if cmd == nil {
+			fmt.Fprintf(stderr, "downloader: unknown command %q\n\n", name)
+			printUsage(stderr, cmds)
+			return exitUsage
+		}
+		if err := cmd.flags.Parse(cmdArgs[1:]); err != nil {
+			return parseExit(err)
+		}
+		cmdArgs = cmd.flags.Args()
+	}
+
+	if err := c.load(); err != nil {
+		fmt.Fprintf(stderr, "downloader: %v\n", err)
+		return exitFailure
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := cmd.run(ctx, cmdArgs)
+	var ue usageError
+	switch {
+	case errors.As(err, &ue):
+		fmt.Fprintf(stderr, "downloader %s: %v\n\n", cmd.name, err)
+		cmd.flags.Usage()
+		return exitUsage
+	case err != nil:
+		fmt.Fprintf(stderr, "downloader %s: %v\n", cmd.name, err)
+		return exitFailure
+	}
+	return 0
+}
+
+func (c *cli) commands() []*command {
+	return []*command{
+		{
+			name:    "download",
+			args:    "[EDITION...]",
+			summary: "Download new releases of the editions, defaulting to the configured ones",
+			setup: func(fs *flag.FlagSet) {
+				fs.BoolVar(&c.dryRun, "dry-run", false, "check for new releases like status without writing anything")
+				fs.BoolVar(&c.jsonOutput, "json", false, "print the dry-run result as JSON")
+			},
+			run: c.download,
+		},
+		{
+			name:    "status",
+			args:    "[EDITION...]",
+			summary: "Show the local build, the remote release, pending updates and backups of the editions",
+			setup: func(fs *flag.FlagSet) {
+				fs.BoolVar(&c.jsonOutput, "json", false, "print the status as JSON")
+			},
+			run: c.status,
+		},
+		{
+			name:    "verify",
+			args:    "[EDITION...]",
+			summary: "Check that the local databases of the editions are valid",
+			setup: func(fs *flag.FlagSet) {
+				fs.BoolVar(&c.jsonOutput, "json", false, "print one JSON object per edition")
+			},
+			run: c.verify,
+		},
+		{
+			name:    "inspect",
+			args:    "[EDITION|FILE...]",
+			summary: "Show the metadata and network counts of databases, defaulting to the configured editions",
+			setup: func(fs *flag.FlagSet) {
+				fs.BoolVar(&c.jsonOutput, "json", false, "print one JSON object per database")
+			},
+			run: c.inspect,
+		},
+		{
+			name:    "diff",
+			args:    "OLD NEW",
+			summary: "Show the networks added, removed or changed between two database files",
+			setup: func(fs *flag.FlagSet) {
+				fs.BoolVar(&c.jsonOutput, "json", false, "print the diff as JSON")
+				fs.BoolVar(&c.summaryOnly, "summary", false, "print only the counts and reassignments, not the networks")
+				fs.IntVar(&c.top, "top", 10, "`number` of country and ASN reassignments to print, 0 for all")
+			},
+			run: c.diff,
+		},
+		{
+			name:    "lookup",
+			args:    "[IP...]",
+			summary: "Look up IP addresses given as arguments or read from stdin in the local databases",
+			setup: func(fs *flag.FlagSet) {
+				fs.StringVar(&c.format, "format", "text", "output `format`: text, json (one object per line), csv or tsv")
+				fs.BoolVar(&c.jsonOutput, "json", false, "shorthand for -format json")
+				fs.StringVar(&c.fields, "fields", defaultLookupFields, "comma-separated `fields` to print")
+				fs.BoolVar(&c.extract, "extract", false, "look up every address found in the lines read from stdin, e.g. of logs")
+				fs.StringVar(&c.regex, "regex", "", "`pattern` finding addresses in lines read from stdin, using its first group if any; implies -extract")
+				fs.BoolVar(&c.noHeader, "no-header", false, "omit the header of text, csv and tsv output")
+			},
+			run: c.lookup,
+		},
+		{
+			name:    "enrich",
+			args:    "[FILE...]",
+			summary: "Append the IP info of the client address to access log lines read from the files or stdin",
+			setup: func(fs *flag.FlagSet) {
+				fs.StringVar(&c.logFormat, "log-format", "combined", "`format` of the log lines: combined (or common) or json")
+				fs.StringVar(&c.ipField, "ip-field", "remote_addr", "`key` of the address in JSON lines, with dots for nested objects")
+				fs.StringVar(&c.enrichFields, "fields", defaultEnrichFields, "comma-separated `fields` to append, like those of lookup")
+				fs.StringVar(&c.prefix, "prefix", "", "`prefix` of the appended field names")
+				fs.IntVar(&c.workers, "workers", runtime.GOMAXPROCS(0), "`number` of lookup workers")
+				fs.BoolVar(&c.ordered, "ordered", false, "write the lines in input order")
+				fs.BoolVar(&c.stats, "stats", false, "print line counts and throughput to stderr when done")
+				fs.DurationVar(&c.statsInterval, "stats-interval", 0, "also print them every `interval`")
+			},
+			run: c.enrich,
+		},
+		{
+			name:    "serve",
+			summary: "Serve IP info over HTTP and keep the databases up to date, like cmd/server",
+			setup: func(fs *flag.FlagSet) {
+				fs.StringVar(&c.addr, "addr", "", "`address` to listen on, overriding BIND_ADDR")
+				fs.StringVar(&c.metricsAddr, "metrics-addr", "", "`address` of the metrics server, overriding METRICS_ADDR")
+			},
+			run: c.serve,
+		},
+		{
+			name:    "rollback",
+			args:    "EDITION [BUILD_EPOCH]",
+			summary: "Restore a backup generation, by default the newest one older than the current database",
+			run:     c.rollback,
+		},
+	}
+}
+
+// flagSet returns a flag set with the common flags and those of setup.
+func (c *cli) flagSet(name string, output io.Writer, setup func(*flag.FlagSet)) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&c.envFile, "env-file", "", "`file` to read environment variables from (default .env if present)")
+	for _, name := range []string{"f", "config-file"} {
+		fs.StringVar(&c.confFile, name, "", "geoipupdate `GeoIP.conf` to read the configuration from")
+	}
+	for _, name := range []string{"d", "database-directory"} {
+		fs.StringVar(&c.dir, name, "", "`directory` of the databases, overriding DatabaseDirectory and MAXMIND_BASE_PATH")
+	}
+	fs.StringVar(&c.editions, "editions", "", "comma-separated `editions`, overriding EditionIDs and MAXMIND_EDITION_IDS")
+	if setup != nil {
+		setup(fs)
+	}
+	return fs
+}
+
+func parseExit(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	return exitUsage
+}
+
+func lookupCommand(cmds []*command, name string) *command {
+	for _, cmd := range cmds {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func printUsage(w io.Writer, cmds []*command) {
+	fmt.Fprintf(w, "usage: downloader [flags] [command] [flags] [args]\n\nCommands:\n")
+	for _, cmd := range cmds {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nWithout a command, downloader downloads. Run \"downloader help COMMAND\" for the flags of a command.\n")
+}
+
+func help(w io.Writer, cmds []*command, args []string) int {
+	if len(args) == 0 {
+		printUsage(w, cmds)
+		return 0
+	}
+	cmd := lookupCommand(cmds, args[0])
+	if cmd == nil {
+		fmt.Fprintf(w, "downloader: unknown command %q\n", args[0])
+		return exitUsage
+	}
+	cmd.flags.Usage()
+	return 0
+}
+
+// load reads the env file and the GeoIP.conf given by the flags.
+func (c *cli) load() error {
+	if c.envFile != "" {
+		if err := godotenv.Load(c.envFile); err != nil {
+			return err
+		}
+	} else if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if c.confFile != "" {
+		conf, err := mmdb.LoadGeoIPConf(c.confFile)
+		if err != nil {
+			return err
+		}
+		c.conf = conf
+	}
+	return nil
+}
+
+// dataDir returns the database directory: the flag, then the GeoIP.conf,
+// then MAXMIND_BASE_PATH.
+func (c *cli) dataDir() string {
+	switch {
+	case c.dir != "":
+		return c.dir
+	case c.conf != nil && c.conf.DatabaseDirectory != "":
+		return c.conf.DatabaseDirectory
+	case os.Getenv(mmdb.MaxmindBasePath) != "":
+		return os.Getenv(mmdb.MaxmindBasePath)
+	}
+	return "."
+}
+
+// editionList returns the editions given as arguments, otherwise the
+// flag, the GeoIP.conf or MAXMIND_EDITION_IDS. They are validated like
+// the downloader does, so that editions of other sources are accepted.
+func (c *cli) editionList(args []string) ([]string, error) {
+	var editions []string
+	switch {
+	case len(args) > 0:
+		editions = args
+	case c.editions != "":
+		editions = mmdb.SplitEditions(c.editions)
+	case c.conf != nil && len(c.conf.EditionIDs) > 0:
+		editions = c.conf.EditionIDs
+	default:
+		editions = mmdb.SplitEditions(os.Getenv(mmdb.MaxmindEditionIds))
+	}
+	// the Downloader validates the editions it is given
+	if _, err := c.localDownloader(mmdb.WithEditions(editions...)); err != nil {
+		return nil, err
+	}
+	return editions, nil
+}
+
+// downloader returns a Downloader configured by the environment, the
+// GeoIP.conf and the flags, in increasing precedence.
+func (c *cli) downloader(extra ...mmdb.Option) (*mmdb.Downloader, error) {
+	opts, err := c.downloaderOptions(extra)
 	if err != nil {
-		log.Fatalf("error creating downloader: %v", err)
+		return nil, err
 	}
+	return mmdb.NewDownloader(opts...)
+}
+
+// localDownloader is like downloader for commands that only work on the
+// local databases, so that they run without credentials.
+func (c *cli) localDownloader(extra ...mmdb.Option) (*mmdb.Downloader, error) {
+	opts, err := c.downloaderOptions(extra)
+	if err != nil {
+		return nil, err
+	}
+	return mmdb.NewLocalDownloader(opts...)
+}
+
+// downloaderOptions returns the options of the GeoIP.conf and the flags,
+// followed by extra.
+func (c *cli) downloaderOptions(extra []mmdb.Option) ([]mmdb.Option, error) {
+	var opts []mmdb.Option
+	if c.conf != nil {
+		confOpts, err := c.conf.Options()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, confOpts...)
+	}
+	if c.dir != "" {
+		opts = append(opts, mmdb.WithBasePath(c.dir))
+	}
+	if c.editions != "" {
+		opts = append(opts, mmdb.WithEditions(mmdb.SplitEditions(c.editions)...))
+	}
+	return append(opts, extra...), nil
+}
 
-	dbs := []string{mmdb.CityDatabase, mmdb.CountryDatabase, mmdb.ASNDatabase}
-	err = d.DownloadDatabases(context.Background(), dbs...)
+// clientOptions returns the options to open the configured databases.
+func (c *cli) clientOptions() ([]mmdb.ClientOption, error) {
+	editions, err := c.editionList(nil)
 	if err != nil {
-		log.Fatalf("error downloading databases: %v", err)
+		return nil, err
 	}
+	return []mmdb.ClientOption{mmdb.WithDataDirectory(c.dataDir()), mmdb.WithClientEditions(editions...)}, nil
 }
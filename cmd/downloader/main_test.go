@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NoUmlautsAllowed/go-mmdb"
+	"github.com/NoUmlautsAllowed/go-mmdb/mmdbtest"
+)
+
+const (
+	testAccountID  = "123456"
+	testLicenseKey = "test_license_key"
+)
+
+// newTestConf starts a fake MaxMind server publishing City and ASN and
+// returns a GeoIP.conf for it, with the databases in the returned directory.
+// The environment is cleared so that only the GeoIP.conf configures the
+// downloader.
+func newTestConf(t *testing.T) (conf, dir string) {
+	t.Helper()
+	clearEnv(t)
+
+	srv := mmdbtest.NewServer(testAccountID, testLicenseKey)
+	t.Cleanup(srv.Close)
+	modTime := time.Date(2023, 11, 14, 12, 0, 0, 0, time.UTC)
+	for _, edition := range []string{mmdb.CityDatabase, mmdb.ASNDatabase} {
+		srv.SetDatabase(edition, mmdbtest.DefaultDatabase(edition), modTime)
+	}
+
+	dir = t.TempDir()
+	conf = filepath.Join(t.TempDir(), "GeoIP.conf")
+	content := fmt.Sprintf("AccountID %s\nLicenseKey %s\nEditionIDs %s %s\nDatabaseDirectory %s\nHost %s\n",
+		testAccountID, testLicenseKey, mmdb.CityDatabase, mmdb.ASNDatabase, dir, srv.URL)
+	if err := os.WriteFile(conf, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return conf, dir
+}
+
+// clearEnv clears the variables configuring the downloader and the client.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{
+		mmdb.MaxmindAccountId, mmdb.MaxmindLicenseKey, mmdb.MaxmindEditionIds, mmdb.MaxmindBasePath,
+		mmdb.MaxmindSource, mmdb.MaxmindSchedule, mmdb.MaxmindStartupJitter, mmdb.MaxmindLock,
+		mmdb.MaxmindBackupRetention, mmdb.MaxmindPinnedGenerations,
+		mmdb.MaxmindAccountIdFile, mmdb.MaxmindLicenseKeyFile, mmdb.MaxmindGeoIPConf,
+		mmdb.MaxmindProxy, mmdb.MaxmindCACerts, mmdb.MaxmindClientCert, mmdb.MaxmindClientKey, mmdb.MaxmindUserAgent,
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestRunWithoutArguments(t *testing.T) {
+	conf, dir := newTestConf(t)
+
+	// the configured editions apply without arguments, as from cron or
+	// as the entry point of the Docker image
+	for _, args := range [][]string{
+		{"-f", conf, "-dry-run"},
+		{"-f", conf, "status"},
+		{"-f", conf},
+		{"-f", conf, "download"},
+		{"-f", conf, "verify"},
+	} {
+		var stderr bytes.Buffer
+		if code := run(args, &stderr); code != 0 {
+			t.Errorf("%v: expected exit code 0, got %d: %s", args, code, stderr.String())
+		}
+	}
+	for _, edition := range []string{mmdb.CityDatabase, mmdb.ASNDatabase} {
+		if _, err := os.Stat(filepath.Join(dir, edition+".mmdb")); err != nil {
+			t.Errorf("expected %s to be downloaded, got %v", edition, err)
+		}
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	conf, _ := newTestConf(t)
+
+	tests := []struct {
+		args     []string
+		expected int
+		output   string
+	}{
+		{[]string{"help"}, 0, "Commands:"},
+		{[]string{"help", "lookup"}, 0, "usage: downloader lookup"},
+		{[]string{"nope"}, exitUsage, `unknown command "nope"`},
+		{[]string{"-nope"}, exitUsage, "flag provided but not defined"},
+		{[]string{"-f", conf, "status", "GeoLite2-Foo"}, exitUsage, "unknown edition"},
+		{[]string{"-f", conf, "rollback"}, exitUsage, "usage: downloader rollback"},
+		{[]string{"-env-file", filepath.Join(t.TempDir(), "missing.env"), "status"}, exitFailure, "missing.env"},
+	}
+
+	for _, tt := range tests {
+		var stderr bytes.Buffer
+		if code := run(tt.args, &stderr); code != tt.expected {
+			t.Errorf("%v: expected exit code %d, got %d: %s", tt.args, tt.expected, code, stderr.String())
+		}
+		if !strings.Contains(stderr.String(), tt.output) {
+			t.Errorf("%v: expected %q in output, got %q", tt.args, tt.output, stderr.String())
+		}
+	}
+}
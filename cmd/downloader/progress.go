@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/NoUmlautsAllowed/go-mmdb"
+)
+
+// progressPrinter renders download progress on stderr. On a terminal it
+// redraws one line with all running transfers and prints finished ones
+// above it; otherwise it only prints finished transfers.
+type progressPrinter struct {
+	mu      sync.Mutex
+	tty     bool
+	running map[string]mmdb.Progress
+}
+
+func newProgressPrinter() *progressPrinter {
+	return &progressPrinter{
+		tty:     isTerminal(os.Stderr),
+		running: make(map[string]mmdb.Progress),
+	}
+}
+
+func (pp *progressPrinter) update(p mmdb.Progress) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	if !pp.tty {
+		if p.Done {
+			fmt.Fprintln(os.Stderr, p)
+		}
+		return
+	}
+
+	fmt.Fprint(os.Stderr, "\r\033[K")
+	if p.Done {
+		delete(pp.running, p.Edition)
+		fmt.Fprintln(os.Stderr, p)
+	} else {
+		pp.running[p.Edition] = p
+	}
+
+	var parts []string
+	for _, edition := range slices.Sorted(maps.Keys(pp.running)) {
+		parts = append(parts, pp.running[edition].String())
+	}
+	fmt.Fprint(os.Stderr, strings.Join(parts, " | "))
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/NoUmlautsAllowed/go-mmdb"
+)
+
+// rollback restores a backup generation: rollback EDITION [BUILD_EPOCH].
+// Without a build epoch it restores the newest backup older than the
+// current database.
+func (c *cli) rollback(_ context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usageError("expected EDITION and an optional BUILD_EPOCH")
+	}
+	edition := args[0]
+
+	var generation uint64
+	if len(args) == 2 {
+		var err error
+		if generation, err = strconv.ParseUint(args[1], 10, 0); err != nil {
+			return usageError(fmt.Sprintf("invalid build epoch %q", args[1]))
+		}
+	}
+
+	d, err := c.localDownloader()
+	if err != nil {
+		return err
+	}
+	g, err := d.Rollback(edition, uint(generation))
+	if err != nil {
+		if gens, genErr := d.Generations(edition); genErr == nil && len(gens) > 0 {
+			printGenerations(gens)
+		}
+		return fmt.Errorf("rolling back %s: %w", edition, err)
+	}
+	fmt.Printf("%s rolled back to build %d (%s)\n", edition, g.BuildEpoch, g.BuildTime().Format(time.RFC3339))
+	return nil
+}
+
+func printGenerations(gens []mmdb.Generation) {
+	for _, g := range gens {
+		marker := " "
+		if g.Current {
+			marker = "*"
+		}
+		fmt.Fprintf(os.Stderr, "%s %d  %s\n", marker, g.BuildEpoch, g.BuildTime().Format(time.RFC3339))
+	}
+}
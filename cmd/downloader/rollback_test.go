@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/NoUmlautsAllowed/go-mmdb"
+	"github.com/NoUmlautsAllowed/go-mmdb/mmdbtest"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+func TestRollbackWithoutCredentials(t *testing.T) {
+	// rollback only touches local files, so it must work when the
+	// credentials are missing
+	clearEnv(t)
+	dir := t.TempDir()
+	const old, cur = 1700000000, 1700003600
+	if err := mmdbtest.WriteDatabase(filepath.Join(dir, "GeoLite2-City.1700000000.mmdb"), mmdb.CityDatabase, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmdbtest.WriteDatabase(filepath.Join(dir, "GeoLite2-City.mmdb"), mmdb.CityDatabase, cur); err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	if code := run([]string{"rollback", "-d", dir, mmdb.CityDatabase}, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+
+	db, err := maxminddb.Open(filepath.Join(dir, "GeoLite2-City.mmdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Metadata.BuildEpoch != old {
+		t.Errorf("expected build %d after rollback, got %d", old, db.Metadata.BuildEpoch)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/NoUmlautsAllowed/go-mmdb/internal/serve"
+)
+
+// serve runs the server of cmd/server. Without MaxMind credentials it
+// serves the databases on disk without updating them.
+func (c *cli) serve(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return usageError("serve takes no arguments")
+	}
+
+	cfg := serve.ConfigFromEnv()
+	if c.addr != "" {
+		cfg.Addr = c.addr
+	}
+	if c.metricsAddr != "" {
+		cfg.MetricsAddr = c.metricsAddr
+	}
+	// the mirror serves the directory the downloader writes to
+	cfg.DataDirectory = c.dataDir()
+
+	d, err := c.downloader()
+	if err != nil {
+		log.Printf("Downloader not configured: %v. Continuing without downloader.", err)
+	}
+	opts, err := c.clientOptions()
+	if err != nil {
+		return err
+	}
+	return serve.Run(ctx, cfg, d, opts...)
+}
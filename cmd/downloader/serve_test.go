@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NoUmlautsAllowed/go-mmdb"
+	"github.com/NoUmlautsAllowed/go-mmdb/mmdbtest"
+)
+
+// freeAddr returns a local address that is free to listen on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestServeMirrorsDatabaseDirectory(t *testing.T) {
+	clearEnv(t)
+	t.Setenv(mmdb.MirrorAccountId, "mirror")
+	t.Setenv(mmdb.MirrorLicenseKey, "mirror-key")
+	// the flag wins over the variable, for the mirror as well
+	t.Setenv(mmdb.MaxmindBasePath, t.TempDir())
+
+	dir := t.TempDir()
+	for _, edition := range mmdb.DefaultEditions {
+		if err := mmdbtest.WriteDatabase(filepath.Join(dir, edition+".mmdb"), edition, 1700000000); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &cli{}
+	cmd := lookupCommand(c.commands(), "serve")
+	addr := freeAddr(t)
+	fs := c.flagSet("serve", io.Discard, cmd.setup)
+	if err := fs.Parse([]string{"-d", dir, "-addr", addr, "-metrics-addr", freeAddr(t)}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.serve(ctx, fs.Args()) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	}()
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/geoip/databases/"+mmdb.CityDatabase+"/download?suffix=tar.gz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("mirror", "mirror-key")
+	var resp *http.Response
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		if resp, err = http.DefaultClient.Do(req); err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("mirror request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the database of -d to be mirrored, got status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/gzip" {
+		t.Errorf("expected an archive, got %q", ct)
+	}
+}
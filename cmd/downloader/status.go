@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// status prints the local and remote state of the editions given as
+// arguments, defaulting to the configured ones, as a table or JSON. It
+// fails if an edition could not be checked.
+func (c *cli) status(ctx context.Context, args []string) error {
+	d, err := c.downloader()
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		if err := d.ValidateEditions(args); err != nil {
+			return usageError(err.Error())
+		}
+	}
+	report := d.Status(ctx, args...)
+	if c.jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		fmt.Print(report)
+	}
+	return report.Err()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// verification is the result of verifying the database of an edition.
+type verification struct {
+	Edition      string `json:"edition"`
+	Path         string `json:"path"`
+	DatabaseType string `json:"database_type,omitempty"`
+	BuildEpoch   uint   `json:"build_epoch,omitempty"`
+	IPVersion    uint   `json:"ip_version,omitempty"`
+	NodeCount    uint   `json:"node_count,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// verify checks the search tree, data section and metadata of the local
+// database of each edition. It fails if any of them is missing or
+// invalid.
+func (c *cli) verify(_ context.Context, args []string) error {
+	editions, err := c.editionList(args)
+	if err != nil {
+		return usageError(err.Error())
+	}
+
+	var results []verification
+	var errs []error
+	for _, edition := range editions {
+		v := verifyDatabase(edition, filepath.Join(c.dataDir(), edition+".mmdb"))
+		if v.Error != "" {
+			errs = append(errs, fmt.Errorf("%s: %s", edition, v.Error))
+		}
+		results = append(results, v)
+	}
+
+	if c.jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		for _, v := range results {
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "EDITION\tTYPE\tBUILD\tIP VERSION\tNODES\tERROR")
+		for _, v := range results {
+			build := "-"
+			if v.BuildEpoch != 0 {
+				build = time.Unix(int64(v.BuildEpoch), 0).UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n", v.Edition, v.DatabaseType, build, v.IPVersion, v.NodeCount, v.Error)
+		}
+		tw.Flush()
+	}
+	return errors.Join(errs...)
+}
+
+func verifyDatabase(edition, path string) verification {
+	v := verification{Edition: edition, Path: path}
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	defer reader.Close()
+
+	m := reader.Metadata
+	v.DatabaseType, v.BuildEpoch, v.IPVersion, v.NodeCount = m.DatabaseType, m.BuildEpoch, m.IPVersion, m.NodeCount
+	if err := reader.Verify(); err != nil {
+		v.Error = err.Error()
+	}
+	return v
+}
@@ -0,0 +1,212 @@
+package mmdb
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	MaxmindAccountIdFile  = "MAXMIND_ACCOUNT_ID_FILE"
+	MaxmindLicenseKeyFile = "MAXMIND_LICENSE_KEY_FILE"
+	MaxmindGeoIPConf      = "MAXMIND_GEOIP_CONF"
+)
+
+// Credentials are a MaxMind account ID and license key. They print with the
+// license key redacted.
+type Credentials struct {
+	AccountID  string
+	LicenseKey string
+}
+
+func (c Credentials) String() string {
+	key := ""
+	if c.LicenseKey != "" {
+		key = redacted
+	}
+	return fmt.Sprintf("{AccountID:%s LicenseKey:%s}", c.AccountID, key)
+}
+
+func (c Credentials) GoString() string {
+	return "mmdb.Credentials" + c.String()
+}
+
+// CredentialProvider supplies the MaxMind credentials. A provider that is
+// not configured returns empty Credentials and no error.
+type CredentialProvider interface {
+	Credentials() (Credentials, error)
+}
+
+// WithCredentials sets where the MaxMind credentials come from instead of
+// DefaultCredentials. They are read by NewDownloader and again before each
+// DownloadDatabases run, so rotated secrets are picked up.
+func WithCredentials(p CredentialProvider) Option {
+	return func(d *Downloader) {
+		d.credentials = p
+	}
+}
+
+// DefaultCredentials reads MAXMIND_ACCOUNT_ID and MAXMIND_LICENSE_KEY, then
+// the files named by MAXMIND_ACCOUNT_ID_FILE and MAXMIND_LICENSE_KEY_FILE,
+// e.g. Docker or Kubernetes secrets, then the GeoIP.conf named by
+// MAXMIND_GEOIP_CONF. Each value is taken from the first source setting it.
+func DefaultCredentials() CredentialProvider {
+	return CredentialChain{
+		EnvCredentials{},
+		FileCredentials{
+			AccountIDFile:  os.Getenv(MaxmindAccountIdFile),
+			LicenseKeyFile: os.Getenv(MaxmindLicenseKeyFile),
+		},
+		GeoIPConfCredentials{Path: os.Getenv(MaxmindGeoIPConf)},
+	}
+}
+
+// CredentialChain asks each provider in turn and takes each value from the
+// first one setting it, so that e.g. the account ID may come from the
+// environment and the license key from a secret file.
+type CredentialChain []CredentialProvider
+
+func (c CredentialChain) Credentials() (Credentials, error) {
+	var creds Credentials
+	for _, p := range c {
+		if creds.AccountID != "" && creds.LicenseKey != "" {
+			break
+		}
+		next, err := p.Credentials()
+		if err != nil {
+			return Credentials{}, err
+		}
+		if creds.AccountID == "" {
+			creds.AccountID = next.AccountID
+		}
+		if creds.LicenseKey == "" {
+			creds.LicenseKey = next.LicenseKey
+		}
+	}
+	return creds, nil
+}
+
+// EnvCredentials reads MAXMIND_ACCOUNT_ID and MAXMIND_LICENSE_KEY.
+type EnvCredentials struct{}
+
+func (EnvCredentials) Credentials() (Credentials, error) {
+	return Credentials{
+		AccountID:  os.Getenv(MaxmindAccountId),
+		LicenseKey: os.Getenv(MaxmindLicenseKey),
+	}, nil
+}
+
+// FileCredentials reads the account ID and license key from one file each,
+// ignoring surrounding whitespace. Empty paths are skipped.
+type FileCredentials struct {
+	AccountIDFile  string
+	LicenseKeyFile string
+}
+
+func (f FileCredentials) Credentials() (Credentials, error) {
+	var creds Credentials
+	for _, v := range []struct {
+		path  string
+		value *string
+	}{
+		{f.AccountIDFile, &creds.AccountID},
+		{f.LicenseKeyFile, &creds.LicenseKey},
+	} {
+		if v.path == "" {
+			continue
+		}
+		data, err := os.ReadFile(v.path)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("mmdb: read credentials: %w", err)
+		}
+		*v.value = strings.TrimSpace(string(data))
+	}
+	return creds, nil
+}
+
+// GeoIPConfCredentials reads AccountID and LicenseKey from a GeoIP.conf
+// file as used by MaxMind's geoipupdate. An empty Path is skipped.
+type GeoIPConfCredentials struct {
+	Path string
+}
+
+func (g GeoIPConfCredentials) Credentials() (Credentials, error) {
+	if g.Path == "" {
+		return Credentials{}, nil
+	}
+	c, err := LoadGeoIPConf(g.Path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{AccountID: c.AccountID, LicenseKey: c.LicenseKey}, nil
+}
+
+// refreshCredentials reads the credentials again, keeping the current ones
+// if that fails.
+func (d *Downloader) refreshCredentials() {
+	if d.credentials == nil {
+		return
+	}
+	creds, err := d.credentials.Credentials()
+	if err != nil {
+		log.Printf("mmdb: refresh credentials: %v", d.redact(err))
+		return
+	}
+	if creds.AccountID != "" && creds.LicenseKey != "" {
+		d.AccountID, d.LicenseKey = creds.AccountID, creds.LicenseKey
+	}
+}
+
+const redacted = "REDACTED"
+
+// secrets returns the secrets that must not appear in logs and errors.
+func (d *Downloader) secrets() []string {
+	secrets := []string{d.LicenseKey}
+	if d.proxy != nil {
+		if password, ok := d.proxy.User.Password(); ok {
+			secrets = append(secrets, password)
+		}
+	}
+	switch src := d.source.(type) {
+	case *httpSource:
+		secrets = append(secrets, src.licenseKey)
+	case *S3Source:
+		secrets = append(secrets, src.SecretAccessKey)
+	case *OCISource:
+		secrets = append(secrets, src.Password)
+	}
+	return secrets
+}
+
+// redact replaces the Downloader's secrets in the message of err, e.g. a
+// license key in the query of a legacy download URL, keeping err
+// available to errors.Is and errors.As.
+func (d *Downloader) redact(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	for _, secret := range d.secrets() {
+		if secret != "" {
+			msg = strings.ReplaceAll(msg, secret, redacted)
+		}
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{err: err, msg: msg}
+}
+
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,162 @@
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFile writes content to name in dir and returns its path.
+func writeFile(t testing.TB, dir, name, content string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestDefaultCredentials(t *testing.T) {
+	dir := t.TempDir()
+	conf := writeFile(t, dir, "GeoIP.conf", "AccountID 3\nLicenseKey conf-key\n")
+	accountFile := writeFile(t, dir, "account", "2\n")
+	licenseFile := writeFile(t, dir, "license", "  file-key\n")
+
+	tests := []struct {
+		name        string
+		account     string
+		license     string
+		accountFile string
+		licenseFile string
+		conf        string
+		expected    Credentials
+	}{
+		{name: "None"},
+		{name: "Env", account: "1", license: "env-key", conf: conf, expected: Credentials{"1", "env-key"}},
+		{name: "Files", accountFile: accountFile, licenseFile: licenseFile, conf: conf, expected: Credentials{"2", "file-key"}},
+		{name: "GeoIP.conf", conf: conf, expected: Credentials{"3", "conf-key"}},
+		{name: "Mixed", account: "1", licenseFile: licenseFile, expected: Credentials{"1", "file-key"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(MaxmindAccountId, tt.account)
+			t.Setenv(MaxmindLicenseKey, tt.license)
+			t.Setenv(MaxmindAccountIdFile, tt.accountFile)
+			t.Setenv(MaxmindLicenseKeyFile, tt.licenseFile)
+			t.Setenv(MaxmindGeoIPConf, tt.conf)
+
+			got, err := DefaultCredentials().Credentials()
+			if err != nil {
+				t.Fatalf("Credentials: %v", err)
+			}
+			// %v redacts the license key, so compare it separately
+			if got.AccountID != tt.expected.AccountID {
+				t.Errorf("expected account %q, got %q", tt.expected.AccountID, got.AccountID)
+			}
+			if got.LicenseKey != tt.expected.LicenseKey {
+				t.Errorf("expected license key %q, got %q", tt.expected.LicenseKey, got.LicenseKey)
+			}
+		})
+	}
+}
+
+func TestCredentialProviderErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		provider CredentialProvider
+	}{
+		{name: "Missing File", provider: FileCredentials{LicenseKeyFile: filepath.Join(dir, "missing")}},
+		{name: "Missing GeoIP.conf", provider: GeoIPConfCredentials{Path: filepath.Join(dir, "missing")}},
+		{name: "Malformed GeoIP.conf", provider: GeoIPConfCredentials{Path: writeFile(t, dir, "bad.conf", "AccountID\n")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.provider.Credentials(); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestGeoIPConfCredentials(t *testing.T) {
+	conf := writeFile(t, t.TempDir(), "GeoIP.conf", `# GeoIP.conf file for geoipupdate
+UserId 42
+LicenseKey	legacy-key
+
+EditionIDs GeoLite2-Country GeoLite2-City
+`)
+	got, err := GeoIPConfCredentials{Path: conf}.Credentials()
+	if err != nil {
+		t.Fatalf("Credentials: %v", err)
+	}
+	if got.AccountID != "42" || got.LicenseKey != "legacy-key" {
+		t.Errorf("expected credentials from UserId and LicenseKey, got %+v", got)
+	}
+}
+
+func TestCredentialsString(t *testing.T) {
+	creds := Credentials{AccountID: "1", LicenseKey: "secret-license"}
+	for _, format := range []string{"%v", "%+v", "%s", "%#v"} {
+		if got := fmt.Sprintf(format, creds); strings.Contains(got, "secret-license") || !strings.Contains(got, redacted) {
+			t.Errorf("expected %s to redact the license key, got %s", format, got)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	d, _ := newTestDownloader(t)
+
+	err := fmt.Errorf("fetch: %w", &StatusError{Method: "GET", StatusCode: 401})
+	err = fmt.Errorf("GET https://download.maxmind.com/app/geoip_download?license_key=%s: %w", testLicenseKey, err)
+	got := d.redact(err)
+	if strings.Contains(got.Error(), testLicenseKey) {
+		t.Errorf("expected license key to be redacted, got %s", got)
+	}
+	if !errors.Is(got, ErrUnauthorized) {
+		t.Errorf("expected redacted error to wrap ErrUnauthorized, got %v", got)
+	}
+
+	plain := errors.New("no secret")
+	if d.redact(plain) != plain {
+		t.Error("expected error without secrets to be returned as is")
+	}
+}
+
+func TestDownloadOneRedactsURL(t *testing.T) {
+	// a legacy download URL carrying the license key, on an unreachable host
+	legacy := "http://127.0.0.1:1/app/geoip_download?edition_id=GeoLite2-City&license_key=" + testLicenseKey
+	d, _ := newTestDownloader(t, WithEditionURL(CityDatabase, legacy), WithChecksum(false))
+
+	res := d.downloadOne(context.Background(), CityDatabase)
+	if res.Err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if strings.Contains(res.Err.Error(), testLicenseKey) {
+		t.Errorf("expected license key to be redacted, got %s", res.Err)
+	}
+}
+
+func TestRefreshCredentials(t *testing.T) {
+	dir := t.TempDir()
+	license := writeFile(t, dir, "license", "rotated-out")
+	d, _ := newTestDownloader(t, WithCredentials(FileCredentials{
+		AccountIDFile:  writeFile(t, dir, "account", testAccountID),
+		LicenseKeyFile: license,
+	}))
+
+	if _, err := d.DownloadDatabases(context.Background(), CityDatabase); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized with the old key, got %v", err)
+	}
+
+	writeFile(t, dir, "license", testLicenseKey)
+	if _, err := d.DownloadDatabases(context.Background(), CityDatabase); err != nil {
+		t.Fatalf("expected rotated key to be picked up, got %v", err)
+	}
+}
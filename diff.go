@@ -0,0 +1,401 @@
+package mmdb
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Kinds of NetworkChange.
+const (
+	NetworkAdded   = "added"
+	NetworkRemoved = "removed"
+	NetworkChanged = "changed"
+)
+
+// NetworkRecord summarizes the data of a network: the country of city and
+// country databases, the autonomous system of ASN databases.
+type NetworkRecord struct {
+	CountryCode  string `json:"country_code,omitempty"`
+	ASN          uint   `json:"asn,omitempty"`
+	Organization string `json:"organization,omitempty"`
+}
+
+// NetworkChange is a network that differs between two databases. Old is
+// nil for added networks, New for removed ones.
+type NetworkChange struct {
+	Kind    string         `json:"kind"`
+	Network string         `json:"network"`
+	Old     *NetworkRecord `json:"old,omitempty"`
+	New     *NetworkRecord `json:"new,omitempty"`
+}
+
+// Reassignment counts the changed networks moved from one country or
+// autonomous system to another.
+type Reassignment struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Networks int    `json:"networks"`
+}
+
+// DatabaseDiff is the result of DiffDatabases.
+type DatabaseDiff struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Changed int `json:"changed"`
+	// Countries and ASNs are the reassignments among the changed
+	// networks, the most frequent first.
+	Countries []Reassignment  `json:"countries"`
+	ASNs      []Reassignment  `json:"asns"`
+	Changes   []NetworkChange `json:"changes,omitempty"`
+}
+
+// DiffDatabases walks the networks of both databases and returns the
+// address ranges only in old (removed), only in new (added) or with
+// different data (changed), as the fewest networks covering them. Data is
+// compared as a whole, so a changed network may keep its country and
+// autonomous system, e.g. if only the city changed.
+func DiffDatabases(old, new *maxminddb.Reader) (*DatabaseDiff, error) {
+	var changes []NetworkChange
+	diff, err := DiffDatabasesFunc(old, new, func(change NetworkChange) error {
+		changes = append(changes, change)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	diff.Changes = changes
+	return diff, nil
+}
+
+// DiffDatabasesFunc is like DiffDatabases but calls fn with each change in
+// address order instead of collecting them in Changes, stopping at the
+// first error fn returns. With a nil fn only the counts and reassignments
+// are computed.
+func DiffDatabasesFunc(old, new *maxminddb.Reader, fn func(NetworkChange) error) (*DatabaseDiff, error) {
+	a, err := newNetworkIter(old)
+	if err != nil {
+		return nil, err
+	}
+	b, err := newNetworkIter(new)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &differ{diff: &DatabaseDiff{}, fn: fn, countries: map[[2]string]int{}, asns: map[[2]string]int{}}
+	var pos netip.Addr
+	if a.cur != nil || b.cur != nil {
+		pos = minStart(a.cur, b.cur)
+	}
+	for a.cur != nil || b.cur != nil {
+		// the segment from pos to the next boundary of either database
+		end, inA, inB := maxAddr, false, false
+		for _, it := range []*networkIter{a, b} {
+			switch {
+			case it.cur == nil:
+			case it.cur.first.Compare(pos) <= 0:
+				end = minAddr(end, it.cur.last)
+				if it == a {
+					inA = true
+				} else {
+					inB = true
+				}
+			default:
+				end = minAddr(end, it.cur.first.Prev())
+			}
+		}
+
+		switch {
+		case !inA && !inB:
+			if err := d.flush(); err != nil {
+				return nil, err
+			}
+			pos = minStart(a.cur, b.cur)
+			continue
+		case !inB:
+			err = d.add(NetworkRemoved, pos, end, a.cur.data, nil)
+		case !inA:
+			err = d.add(NetworkAdded, pos, end, nil, b.cur.data)
+		case a.cur.data.sum != b.cur.data.sum:
+			err = d.add(NetworkChanged, pos, end, a.cur.data, b.cur.data)
+		default:
+			err = d.flush()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, it := range []*networkIter{a, b} {
+			if it.cur != nil && it.cur.last == end {
+				if err := it.advance(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if end == maxAddr {
+			break
+		}
+		pos = end.Next()
+	}
+	if err := d.flush(); err != nil {
+		return nil, err
+	}
+
+	d.diff.Countries = reassignments(d.countries)
+	d.diff.ASNs = reassignments(d.asns)
+	return d.diff, nil
+}
+
+// maxAddr is the last address of the 16-byte address space networks are
+// compared in, with IPv4 networks at ::/96 as in the search tree.
+var maxAddr = netip.AddrFrom16([16]byte{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255})
+
+// networkData is the data of a network, identified by a hash of its
+// contents to compare it across databases.
+type networkData struct {
+	sum    [sha256.Size]byte
+	record NetworkRecord
+}
+
+type networkRange struct {
+	first, last netip.Addr
+	data        *networkData
+}
+
+// networkIter walks the networks of a reader as address ranges, decoding
+// each data record once.
+type networkIter struct {
+	r        *maxminddb.Reader
+	networks *maxminddb.Networks
+	data     map[uintptr]*networkData
+	// cur is the current network, nil after the last one.
+	cur *networkRange
+}
+
+func newNetworkIter(r *maxminddb.Reader) (*networkIter, error) {
+	it := &networkIter{
+		r:        r,
+		networks: r.Networks(maxminddb.SkipAliasedNetworks),
+		data:     make(map[uintptr]*networkData),
+	}
+	return it, it.advance()
+}
+
+func (it *networkIter) advance() error {
+	it.cur = nil
+	if !it.networks.Next() {
+		return it.networks.Err()
+	}
+	var skip struct{}
+	network, err := it.networks.Network(&skip)
+	if err != nil {
+		return err
+	}
+
+	offset, err := it.r.LookupOffset(network.IP)
+	if err != nil {
+		return err
+	}
+	data, ok := it.data[offset]
+	if !ok {
+		if data, err = decodeNetworkData(it.r, offset); err != nil {
+			return fmt.Errorf("%s: %w", network, err)
+		}
+		it.data[offset] = data
+	}
+
+	prefix := treePrefix(network)
+	it.cur = &networkRange{first: prefix.Addr(), last: lastAddr(prefix), data: data}
+	return nil
+}
+
+func decodeNetworkData(r *maxminddb.Reader, offset uintptr) (*networkData, error) {
+	var value any
+	if err := r.Decode(offset, &value); err != nil {
+		return nil, err
+	}
+	// maps are marshaled with sorted keys, so equal data hashes equally
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var rec struct {
+		Country struct {
+			IsoCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		ASN          uint   `maxminddb:"autonomous_system_number"`
+		Organization string `maxminddb:"autonomous_system_organization"`
+	}
+	if err := r.Decode(offset, &rec); err != nil {
+		return nil, err
+	}
+	return &networkData{
+		sum:    sha256.Sum256(b),
+		record: NetworkRecord{CountryCode: rec.Country.IsoCode, ASN: rec.ASN, Organization: rec.Organization},
+	}, nil
+}
+
+// differ collects the changes of DiffDatabases, merging adjacent segments
+// with the same change.
+type differ struct {
+	diff *DatabaseDiff
+	fn   func(NetworkChange) error
+
+	pending          bool
+	kind             string
+	first, last      netip.Addr
+	oldData, newData *networkData
+
+	countries, asns map[[2]string]int
+}
+
+func (d *differ) add(kind string, first, last netip.Addr, oldData, newData *networkData) error {
+	if d.pending && d.kind == kind && d.last.Next() == first && sameData(d.oldData, oldData) && sameData(d.newData, newData) {
+		d.last = last
+		return nil
+	}
+	if err := d.flush(); err != nil {
+		return err
+	}
+	d.pending, d.kind, d.first, d.last, d.oldData, d.newData = true, kind, first, last, oldData, newData
+	return nil
+}
+
+func (d *differ) flush() error {
+	if !d.pending {
+		return nil
+	}
+	d.pending = false
+
+	for _, prefix := range rangePrefixes(d.first, d.last) {
+		change := NetworkChange{Kind: d.kind, Network: displayPrefix(prefix)}
+		if d.oldData != nil {
+			change.Old = &d.oldData.record
+		}
+		if d.newData != nil {
+			change.New = &d.newData.record
+		}
+		if d.fn != nil {
+			if err := d.fn(change); err != nil {
+				return err
+			}
+		}
+
+		switch d.kind {
+		case NetworkAdded:
+			d.diff.Added++
+		case NetworkRemoved:
+			d.diff.Removed++
+		case NetworkChanged:
+			d.diff.Changed++
+			if o, n := change.Old.CountryCode, change.New.CountryCode; o != n {
+				d.countries[[2]string{o, n}]++
+			}
+			if o, n := change.Old.ASN, change.New.ASN; o != n {
+				d.asns[[2]string{asLabel(o), asLabel(n)}]++
+			}
+		}
+	}
+	return nil
+}
+
+func sameData(a, b *networkData) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.sum == b.sum
+}
+
+func asLabel(asn uint) string {
+	if asn == 0 {
+		return ""
+	}
+	return fmt.Sprintf("AS%d", asn)
+}
+
+func reassignments(counts map[[2]string]int) []Reassignment {
+	list := make([]Reassignment, 0, len(counts))
+	for k, n := range counts {
+		list = append(list, Reassignment{From: k[0], To: k[1], Networks: n})
+	}
+	slices.SortFunc(list, func(a, b Reassignment) int {
+		return cmp.Or(cmp.Compare(b.Networks, a.Networks), cmp.Compare(a.From, b.From), cmp.Compare(a.To, b.To))
+	})
+	return list
+}
+
+// treePrefix returns network in the 16-byte address space of the search
+// tree, where IPv4 networks are at ::/96.
+func treePrefix(network *net.IPNet) netip.Prefix {
+	bits, _ := network.Mask.Size()
+	if ip4 := network.IP.To4(); len(network.IP) == net.IPv4len && ip4 != nil {
+		var a [16]byte
+		copy(a[12:], ip4)
+		return netip.PrefixFrom(netip.AddrFrom16(a), bits+96)
+	}
+	addr, _ := netip.AddrFromSlice(network.IP.To16())
+	return netip.PrefixFrom(addr, bits)
+}
+
+// displayPrefix is the inverse of treePrefix.
+func displayPrefix(p netip.Prefix) string {
+	a := p.Addr().As16()
+	if p.Bits() >= 96 && [12]byte(a[:12]) == [12]byte{} {
+		return netip.PrefixFrom(netip.AddrFrom4([4]byte(a[12:])), p.Bits()-96).String()
+	}
+	return p.String()
+}
+
+func lastAddr(p netip.Prefix) netip.Addr {
+	a := p.Addr().As16()
+	for i := p.Bits(); i < 128; i++ {
+		a[i/8] |= 1 << (7 - i%8)
+	}
+	return netip.AddrFrom16(a)
+}
+
+// rangePrefixes returns the fewest prefixes covering first to last.
+func rangePrefixes(first, last netip.Addr) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for {
+		bits := 128
+		for bits > 0 {
+			wider := netip.PrefixFrom(first, bits-1).Masked()
+			if wider.Addr() != first || lastAddr(wider).Compare(last) > 0 {
+				break
+			}
+			bits--
+		}
+		p := netip.PrefixFrom(first, bits)
+		prefixes = append(prefixes, p)
+		end := lastAddr(p)
+		if end.Compare(last) >= 0 {
+			return prefixes
+		}
+		first = end.Next()
+	}
+}
+
+func minStart(a, b *networkRange) netip.Addr {
+	switch {
+	case a == nil:
+		return b.first
+	case b == nil:
+		return a.first
+	}
+	return minAddr(a.first, b.first)
+}
+
+func minAddr(a, b netip.Addr) netip.Addr {
+	if a.Compare(b) <= 0 {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,167 @@
+package mmdb
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/NoUmlautsAllowed/go-mmdb/mmdbtest"
+)
+
+func TestDiffDatabases(t *testing.T) {
+	tests := []struct {
+		name      string
+		edition   string
+		records   []mmdbtest.Record
+		expected  []string
+		countries []Reassignment
+		asns      []Reassignment
+	}{
+		{
+			name:     "Identical",
+			edition:  CityDatabase,
+			records:  mmdbtest.Records,
+			expected: nil,
+		},
+		{
+			name:    "Added Removed Changed",
+			edition: CityDatabase,
+			records: []mmdbtest.Record{
+				{Network: "5.0.0.0/16", CountryCode: "DE", City: "Berlin"},
+				{Network: "81.2.69.0/25", CountryCode: "GB", City: "London"},
+				{Network: "81.2.69.128/25", CountryCode: "FR", City: "Paris"},
+				{Network: "2001:218::/32", CountryCode: "JP"},
+			},
+			expected: []string{
+				"added 5.0.0.0/16",
+				"changed 81.2.69.128/25",
+				"removed 216.160.83.0/24",
+			},
+			countries: []Reassignment{{From: "GB", To: "FR", Networks: 1}},
+		},
+		{
+			name:    "Split With Same Data",
+			edition: CityDatabase,
+			records: []mmdbtest.Record{
+				{Network: "81.2.69.0/25", CountryCode: "FR", City: "Paris"},
+				{Network: "81.2.69.128/25", CountryCode: "FR", City: "Paris"},
+				{Network: "216.160.83.0/24", CountryCode: "US", City: "Milton"},
+				{Network: "2001:218::/32", CountryCode: "JP", City: "Tokyo"},
+			},
+			expected: []string{
+				"changed 81.2.69.0/24",
+				"changed 2001:218::/32",
+			},
+			countries: []Reassignment{{From: "GB", To: "FR", Networks: 1}},
+		},
+		{
+			name:    "ASN Reassignment",
+			edition: ASNDatabase,
+			records: []mmdbtest.Record{
+				{Network: "1.128.0.0/12", ASN: 1221, Organization: "Telstra Pty Ltd"},
+				{Network: "1.144.0.0/12", ASN: 4637, Organization: "Telstra Global"},
+				{Network: "216.160.83.0/24", ASN: 209, Organization: "Qwest Communications Company, LLC"},
+				{Network: "2001:218::/32", ASN: 2914, Organization: "NTT America, Inc."},
+			},
+			expected: []string{"changed 1.144.0.0/12"},
+			asns:     []Reassignment{{From: "AS1221", To: "AS4637", Networks: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, new := openTestDatabase(t, tt.edition, mmdbtest.Records), openTestDatabase(t, tt.edition, tt.records)
+			diff, err := DiffDatabases(old, new)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the summary alone matches without collecting the changes
+			summary, err := DiffDatabasesFunc(old, new, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := *diff
+			expected.Changes = nil
+			if !reflect.DeepEqual(*summary, expected) {
+				t.Errorf("expected summary %+v, got %+v", expected, *summary)
+			}
+
+			var got []string
+			for _, c := range diff.Changes {
+				got = append(got, c.Kind+" "+c.Network)
+				if (c.Old == nil) != (c.Kind == NetworkAdded) || (c.New == nil) != (c.Kind == NetworkRemoved) {
+					t.Errorf("expected records matching kind of %+v", c)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected changes %v, got %v", tt.expected, got)
+			}
+			if n := diff.Added + diff.Removed + diff.Changed; n != len(diff.Changes) {
+				t.Errorf("expected counts to add up to %d changes, got %d", len(diff.Changes), n)
+			}
+			if len(tt.countries) > 0 && !reflect.DeepEqual(diff.Countries, tt.countries) {
+				t.Errorf("expected country reassignments %v, got %v", tt.countries, diff.Countries)
+			}
+			if len(tt.asns) > 0 && !reflect.DeepEqual(diff.ASNs, tt.asns) {
+				t.Errorf("expected ASN reassignments %v, got %v", tt.asns, diff.ASNs)
+			}
+		})
+	}
+}
+
+func TestDiffDatabasesRecords(t *testing.T) {
+	old := openTestDatabase(t, CityDatabase, mmdbtest.Records)
+	diff, err := DiffDatabases(old, openTestDatabase(t, CityDatabase, mmdbtest.Records[:1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []NetworkChange{
+		{Kind: NetworkRemoved, Network: "216.160.83.0/24", Old: &NetworkRecord{CountryCode: "US"}},
+		{Kind: NetworkRemoved, Network: "2001:218::/32", Old: &NetworkRecord{CountryCode: "JP"}},
+	}
+	if !reflect.DeepEqual(diff.Changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, diff.Changes)
+	}
+}
+
+func TestDiffDatabasesFuncError(t *testing.T) {
+	old := openTestDatabase(t, CityDatabase, mmdbtest.Records)
+	stop := errors.New("stop")
+	calls := 0
+	_, err := DiffDatabasesFunc(old, openTestDatabase(t, CityDatabase, mmdbtest.Records[:1]), func(NetworkChange) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("expected %v, got %v", stop, err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+func TestRangePrefixes(t *testing.T) {
+	tests := []struct {
+		first, last string
+		expected    []string
+	}{
+		{"::1.0.0.0", "::1.0.2.255", []string{"::100:0/119", "::100:200/120"}},
+		{"::1.0.0.1", "::1.0.0.3", []string{"::100:1/128", "::100:2/127"}},
+		{"2001:db8::", "2001:db8::ffff", []string{"2001:db8::/112"}},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, p := range rangePrefixes(netip.MustParseAddr(tt.first), netip.MustParseAddr(tt.last)) {
+			got = append(got, p.String())
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s-%s: expected %v, got %v", tt.first, tt.last, tt.expected, got)
+		}
+	}
+	if got := displayPrefix(netip.MustParsePrefix("::100:0/119")); got != "1.0.0.0/23" {
+		t.Errorf("expected 1.0.0.0/23, got %s", got)
+	}
+}
@@ -1,15 +1,21 @@
 package mmdb
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"path"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,13 +26,47 @@ const (
 	MaxmindBasePath   = "MAXMIND_BASE_PATH"
 )
 
+// ErrChecksumMismatch is returned when a downloaded archive does not match
+// the SHA256 checksum published for it.
+var ErrChecksumMismatch = errors.New("mmdb: checksum mismatch")
+
 // Downloader holds configuration & HTTP client for fetching MMDBs.
 type Downloader struct {
-	AccountID  string
-	LicenseKey string
-	BasePath   string
-	client     *http.Client
-	url        string
+	AccountID   string
+	LicenseKey  string
+	BasePath    string
+	Editions    []string
+	credentials CredentialProvider
+	client      *http.Client
+	url         string
+	retry       RetryPolicy
+	timeouts    Timeouts
+	progress    func(Progress)
+	sleep       func(context.Context, time.Duration) error
+
+	proxy        *neturl.URL
+	rootCAs      *x509.CertPool
+	certificates []tls.Certificate
+	userAgent    string
+
+	concurrency int
+	limiter     *rateLimiter
+	retention   int
+	pins        map[string]uint
+	schedule    Schedule
+	jitter      time.Duration
+	lockMode    LockMode
+	lockTimeout time.Duration
+	lockFile    string
+
+	source         Source
+	urls           map[string]string
+	formats        []formatRule
+	verifyChecksum bool
+
+	maxSize       int64
+	preserveTimes bool
+	listeners     listeners
 }
 
 type Option func(*Downloader)
@@ -39,6 +79,51 @@ func WithURL(url string) Option {
 	}
 }
 
+// WithEditionURL downloads edition from url instead of the URL set with
+// WithURL, e.g. from a third-party publisher of a compatible database.
+// edition may be an ID of the publisher, such as dbip-city-lite.
+// The credentials are only sent if url is on the host of the main URL.
+func WithEditionURL(edition, url string) Option {
+	return func(d *Downloader) {
+		d.urls[edition] = url
+	}
+}
+
+// WithChecksum sets whether archives are verified against the SHA256 file
+// published next to them. It is on by default. If a source publishes no
+// checksum, e.g. the URL of the SHA256 file is not found, the search tree
+// and data of the extracted database are verified instead.
+func WithChecksum(verify bool) Option {
+	return func(d *Downloader) {
+		d.verifyChecksum = verify
+	}
+}
+
+// WithEditions sets the editions to download, overriding
+// MAXMIND_EDITION_IDS.
+func WithEditions(editions ...string) Option {
+	return func(d *Downloader) {
+		d.Editions = editions
+	}
+}
+
+// WithBasePath sets the directory databases are stored in, overriding
+// MAXMIND_BASE_PATH.
+func WithBasePath(dir string) Option {
+	return func(d *Downloader) {
+		d.BasePath = dir
+	}
+}
+
+// WithPreserveFileTimes sets whether installed databases get the
+// Last-Modified time of their release as modification time. It is on by
+// default; otherwise they keep the time they were written.
+func WithPreserveFileTimes(preserve bool) Option {
+	return func(d *Downloader) {
+		d.preserveTimes = preserve
+	}
+}
+
 // WithClient sets the HTTP client to use.
 func WithClient(client *http.Client) Option {
 	return func(d *Downloader) {
@@ -47,196 +132,423 @@ func WithClient(client *http.Client) Option {
 }
 
 // NewDownloader reads env vars and returns a configured Downloader.
+// MAXMIND_EDITION_IDS selects the editions, defaulting to DefaultEditions;
+// MAXMIND_BACKUP_RETENTION the number of backups, defaulting to
+// DefaultRetention; MAXMIND_SOURCE where to download from, see
+// ParseSource; MAXMIND_SCHEDULE and MAXMIND_STARTUP_JITTER when Run
+// downloads, see ParseSchedule; MAXMIND_LOCK what to do when another
+// process downloads into the same directory, see LockMode; MAXMIND_PROXY,
+// MAXMIND_CA_CERTS, MAXMIND_CLIENT_CERT, MAXMIND_CLIENT_KEY and
+// MAXMIND_USER_AGENT how the HTTP client connects. The MaxMind
+// credentials come from DefaultCredentials unless WithCredentials is given,
+// and are only required when downloading from MaxMind.
 func NewDownloader(opts ...Option) (*Downloader, error) {
-	account := os.Getenv(MaxmindAccountId)
-	license := os.Getenv(MaxmindLicenseKey)
+	return newDownloader(true, opts)
+}
+
+// NewLocalDownloader is like NewDownloader but neither reads nor requires
+// the MaxMind credentials, for operations on the local databases only, such
+// as Generations and Rollback, e.g. on a host without credentials.
+func NewLocalDownloader(opts ...Option) (*Downloader, error) {
+	return newDownloader(false, opts)
+}
+
+// newDownloader implements NewDownloader, resolving the credentials if
+// credentials is set.
+func newDownloader(credentials bool, opts []Option) (*Downloader, error) {
 	base := os.Getenv(MaxmindBasePath)
 	if base == "" {
 		base = "."
 	}
-	if account == "" || license == "" {
-		return nil, fmt.Errorf("mmdb: missing %s or %s",
-			MaxmindAccountId, MaxmindLicenseKey)
+	source, err := ParseSource(os.Getenv(MaxmindSource))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", MaxmindSource, err)
+	}
+	retention, err := retentionFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	pins, err := pinsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	schedule, jitter, err := scheduleFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	lockMode, err := lockModeFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	httpOpts, err := httpFromEnv()
+	if err != nil {
+		return nil, err
 	}
 
 	d := &Downloader{
-		AccountID:  account,
-		LicenseKey: license,
-		BasePath:   base,
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		url: "https://download.maxmind.com/geoip/databases/%s/download?suffix=tar.gz",
+		BasePath:    base,
+		credentials: DefaultCredentials(),
+		url:         "https://download.maxmind.com/geoip/databases/%s/download?suffix=tar.gz",
+		retry:       DefaultRetryPolicy,
+		timeouts:    DefaultTimeouts,
+		sleep:       sleepContext,
+		userAgent:   DefaultUserAgent(),
+
+		concurrency: DefaultConcurrency,
+		limiter:     &rateLimiter{},
+		retention:   retention,
+		pins:        pins,
+		schedule:    schedule,
+		jitter:      jitter,
+		lockMode:    lockMode,
+		lockTimeout: DefaultLockTimeout,
+
+		urls:           make(map[string]string),
+		formats:        defaultFormats,
+		verifyChecksum: true,
+		maxSize:        DefaultMaxDatabaseSize,
+		preserveTimes:  true,
 	}
 
-	for _, opt := range opts {
+	d.source = &httpSource{d: d}
+
+	for _, opt := range append(append([]Option{source}, httpOpts...), opts...) {
 		opt(d)
 	}
+	if credentials {
+		creds, err := d.credentials.Credentials()
+		if err != nil {
+			return nil, err
+		}
+		d.AccountID, d.LicenseKey = creds.AccountID, creds.LicenseKey
+		if src, ok := d.source.(*httpSource); ok && src.maxmind() && (d.AccountID == "" || d.LicenseKey == "") {
+			return nil, fmt.Errorf("mmdb: missing %s or %s, their _FILE variants or %s",
+				MaxmindAccountId, MaxmindLicenseKey, MaxmindGeoIPConf)
+		}
+	}
+	if d.client == nil {
+		d.client = d.newHTTPClient()
+	}
+	// only read if no option chose the editions, so that an invalid
+	// variable does not fail a Downloader that does not use it
+	if d.Editions == nil {
+		d.Editions = SplitEditions(os.Getenv(MaxmindEditionIds))
+		if err := d.ValidateEditions(d.Editions); err != nil {
+			return nil, fmt.Errorf("%s: %w", MaxmindEditionIds, err)
+		}
+	} else if err := d.ValidateEditions(d.Editions); err != nil {
+		return nil, err
+	}
+	if d.lockMode == LockOff && d.lockFile != "" {
+		return nil, fmt.Errorf("mmdb: lock file %s set with lock mode %s", d.lockFile, LockOff)
+	}
 
 	return d, nil
 }
 
-// DownloadDatabases downloads (or skips) each requested DB.
-func (d *Downloader) DownloadDatabases(ctx context.Context, dbs ...string) error {
-	if err := os.MkdirAll(d.BasePath, 0o755); err != nil {
-		return fmt.Errorf("mkdir %q: %w", d.BasePath, err)
+// DownloadDatabases downloads (or skips) each requested DB, up to the
+// configured concurrency in parallel. Without arguments it downloads the
+// configured Editions. Duplicates are downloaded once, so that no two
+// workers write the same files.
+// The report lists every edition; the error joins all per-edition failures.
+func (d *Downloader) DownloadDatabases(ctx context.Context, dbs ...string) (*DownloadReport, error) {
+	if len(dbs) == 0 {
+		dbs = d.Editions
 	}
+	dbs = uniqueEditions(dbs)
 
-	for _, db := range dbs {
-		if err := d.downloadOne(ctx, db); err != nil {
-			log.Printf("mmdb [%s]: %v", db, err)
+	if err := os.MkdirAll(d.BasePath, 0o755); err != nil {
+		return nil, fmt.Errorf("mkdir %q: %w", d.BasePath, err)
+	}
+	d.refreshCredentials()
+
+	report := &DownloadReport{Results: make([]DownloadResult, len(dbs))}
+	if d.lockFile != "" {
+		unlock, err := d.lock(ctx, "all", d.lockFile)
+		if errors.Is(err, ErrLocked) {
+			log.Printf("mmdb: %s locked by another process, skipping", d.lockFile)
+			for i, db := range dbs {
+				report.Results[i] = DownloadResult{Edition: db, Status: StatusLocked}
+			}
+			return report, nil
+		}
+		if err != nil {
+			return nil, err
 		}
+		defer unlock()
+	}
+	sem := make(chan struct{}, max(d.concurrency, 1))
+	var wg sync.WaitGroup
+	for i, db := range dbs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			res := d.downloadOne(ctx, db)
+			if res.Err != nil {
+				log.Printf("mmdb [%s]: %v", db, res.Err)
+			}
+			report.Results[i] = res
+		}()
 	}
-	return nil
+	wg.Wait()
+	return report, report.Err()
 }
 
-func (d *Downloader) downloadOne(ctx context.Context, db string) error {
-	var status string = "success"
+func (d *Downloader) downloadOne(ctx context.Context, db string) (res DownloadResult) {
+	start := time.Now()
+	res = DownloadResult{Edition: db, Status: StatusSuccess}
 	defer func() {
-		DownloadTotal.WithLabelValues(db, status).Inc()
+		res.Duration = time.Since(start)
+		res.Err = d.redact(res.Err)
+		if res.Err != nil && res.Status == StatusSuccess {
+			res.Status = StatusFailure
+		}
+		DownloadTotal.WithLabelValues(db, string(res.Status)).Inc()
+		// a locked edition was not checked, so it must not look fresh
+		if res.Status == StatusSuccess || res.Status == StatusSkipped {
+			DownloadLastSuccessTimestamp.WithLabelValues(db).SetToCurrentTime()
+		}
 	}()
 
-	url := fmt.Sprintf(
-		d.url,
-		db,
-	)
-
-	// 1) fetch remote build time
-	remoteTime, err := d.fetchRemoteTime(ctx, url)
+	// another process may be downloading into the same directory
+	unlock, err := d.lockEdition(ctx, db)
+	if errors.Is(err, ErrLocked) {
+		res.Status = StatusLocked
+		log.Printf("mmdb [%s] locked by another process, skipping", db)
+		return res
+	}
 	if err != nil {
-		status = "failure"
-		return fmt.Errorf("fetch remote time: %w", err)
+		res.Err = err
+		return res
 	}
+	defer unlock()
 
 	localFile := dbPath(d.BasePath, db)
-	if !needsDownload(localFile, remoteTime) {
-		status = "skipped"
-		info, _ := os.Stat(localFile)
-		log.Printf("mmdb [%s] up to date (%s)", db, info.ModTime().UTC())
-		return nil
+	part := partPath(d.BasePath, db)
+	prev := loadState(d.BasePath, db)
+
+	rel, err := d.fetch(ctx, db, prev.release(), part)
+	res.Bytes = rel.Bytes
+	if errors.Is(err, ErrNotModified) {
+		res.Status = StatusSkipped
+		res.RemoteTime = prev.lastModified()
+		log.Printf("mmdb [%s] up to date (build %s)", db, time.Unix(int64(prev.BuildEpoch), 0).UTC())
+		return res
 	}
+	if err != nil {
+		res.Err = fmt.Errorf("fetch: %w", err)
+		return res
+	}
+	res.RemoteTime = rel.LastModified
 
+	archive, err := os.Open(part)
+	if err != nil {
+		res.Err = err
+		return res
+	}
 	tmp := localFile + ".tmp"
-	if err := d.fetchAndExtract(ctx, url, tmp); err != nil {
-		status = "failure"
-		return fmt.Errorf("download+extract: %w", err)
+	var notices map[string][]byte
+	if info, statErr := archive.Stat(); statErr != nil {
+		err = statErr
+	} else {
+		format := d.archiveFormat(rel.Name, rel.ContentType, archive)
+		notices, err = extract(archive, info.Size(), format, rel.SHA256, tmp, d.maxSize)
+	}
+	archive.Close()
+	// the part file was complete, so it is never resumed, whatever the result
+	cur := loadState(d.BasePath, db)
+	d.discardPartial(db, &cur)
+	if err != nil {
+		if errors.Is(err, ErrChecksumMismatch) {
+			res.Status = StatusChecksumMismatch
+		}
+		res.Err = fmt.Errorf("download+extract: %w", err)
+		return res
+	}
+
+	next := editionState{
+		ETag:         rel.ETag,
+		LastModified: httpTime(rel.LastModified),
+		SHA256:       rel.SHA256,
+	}
+	if next.BuildEpoch, err = buildEpoch(tmp); err != nil {
+		os.Remove(tmp)
+		res.Err = fmt.Errorf("open extracted database: %w", err)
+		return res
+	}
+	// a server ignoring the validators sends the installed release again;
+	// the saved state only has a build epoch if it matches the database
+	if prev.BuildEpoch != 0 && next.BuildEpoch == prev.BuildEpoch {
+		os.Remove(tmp)
+		if err := saveState(d.BasePath, db, next); err != nil {
+			log.Printf("mmdb [%s] save state: %v", db, err)
+		}
+		res.Status = StatusSkipped
+		log.Printf("mmdb [%s] up to date (build %s)", db, time.Unix(int64(next.BuildEpoch), 0).UTC())
+		return res
+	}
+	// sources like a directory or a bucket may publish no checksum
+	if d.verifyChecksum && rel.SHA256 == "" {
+		if err := verifyDatabase(tmp); err != nil {
+			os.Remove(tmp)
+			res.Err = fmt.Errorf("verify extracted database: %w", err)
+			return res
+		}
 	}
 
 	// preserve build timestamp
-	if err := os.Chtimes(tmp, time.Now(), remoteTime); err != nil {
-		log.Printf("mmdb [%s] chtimes: %v", db, err)
+	if d.preserveTimes && !res.RemoteTime.IsZero() {
+		if err := os.Chtimes(tmp, time.Now(), res.RemoteTime); err != nil {
+			log.Printf("mmdb [%s] chtimes: %v", db, err)
+		}
 	}
 
-	// backup old file
-	if err := backupOld(localFile); err != nil {
+	// keep the replaced database as a generation
+	if err := d.backup(db, false); err != nil {
 		log.Printf("mmdb [%s] backup: %v", db, err)
 	}
 
-	// atomically replace
+	// atomically replace, with the temporary file on disk first
+	syncDir(d.BasePath)
 	if err := os.Rename(tmp, localFile); err != nil {
-		status = "failure"
 		os.Remove(tmp)
-		return fmt.Errorf("final rename: %w", err)
+		res.Err = fmt.Errorf("final rename: %w", err)
+		return res
 	}
+	d.installNotices(db, notices)
+	syncDir(d.BasePath)
 
-	log.Printf("mmdb [%s] updated → %s (build %s)", db, localFile, remoteTime.UTC())
-	return nil
-}
-
-func (d *Downloader) fetchRemoteTime(ctx context.Context, url string) (time.Time, error) {
-	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
-	if err != nil {
-		return time.Time{}, err
+	if err := saveState(d.BasePath, db, next); err != nil {
+		log.Printf("mmdb [%s] save state: %v", db, err)
 	}
-	req.SetBasicAuth(d.AccountID, d.LicenseKey)
 
-	resp, err := d.client.Do(req)
-	if err != nil {
-		return time.Time{}, err
-	}
-	defer resp.Body.Close()
+	log.Printf("mmdb [%s] updated → %s (build %s)", db, localFile,
+		time.Unix(int64(next.BuildEpoch), 0).UTC())
+	d.notify(db)
+	return res
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return time.Time{}, fmt.Errorf("HEAD status %d", resp.StatusCode)
+// editionURL returns the URL to download edition from.
+func (d *Downloader) editionURL(edition string) string {
+	if url, ok := d.urls[edition]; ok {
+		return url
 	}
+	return fmt.Sprintf(d.url, edition)
+}
 
-	lm := resp.Header.Get("Last-Modified")
-	if lm == "" {
-		return time.Time{}, fmt.Errorf("missing Last-Modified header")
-	}
-	t, err := time.Parse(http.TimeFormat, lm)
+// checksumURL returns the URL of the SHA256 file published next to the
+// archive at url, i.e. suffix=tar.gz becomes suffix=tar.gz.sha256.
+// URLs without a suffix parameter get ".sha256" appended to their path.
+func checksumURL(archiveURL string) (string, error) {
+	u, err := neturl.Parse(archiveURL)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("parse Last-Modified: %w", err)
+		return "", err
 	}
-	return t, nil
+	q := u.Query()
+	if suffix := q.Get("suffix"); suffix != "" {
+		q.Set("suffix", suffix+".sha256")
+		u.RawQuery = q.Encode()
+	} else {
+		u.Path += ".sha256"
+	}
+	return u.String(), nil
 }
 
-func needsDownload(path string, remote time.Time) bool {
-	info, err := os.Stat(path)
+// fetchChecksum downloads the SHA256 file for the archive at url and
+// returns the hex encoded digest.
+func (d *Downloader) fetchChecksum(ctx context.Context, url string, header http.Header) (string, error) {
+	sumURL, err := checksumURL(url)
 	if err != nil {
-		return true
+		return "", err
 	}
-	return remote.After(info.ModTime())
-}
-
-func (d *Downloader) fetchAndExtract(ctx context.Context, url, tmpFile string) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	resp, err := d.do(ctx, "GET", sumURL, header)
 	if err != nil {
-		return err
+		return "", err
 	}
-	req.SetBasicAuth(d.AccountID, d.LicenseKey)
+	defer resp.Body.Close()
 
-	resp, err := d.client.Do(req)
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer resp.Body.Close()
+	return parseChecksum(data)
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("GET status %d", resp.StatusCode)
+// parseChecksum returns the hex encoded digest of a file in the sha256sum
+// format "<digest>  <filename>".
+func parseChecksum(data []byte) (string, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty sha256 file")
 	}
+	sum := strings.ToLower(fields[0])
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("malformed sha256 %q", fields[0])
+	}
+	return sum, nil
+}
 
-	gz, err := gzip.NewReader(resp.Body)
-	if err != nil {
-		return err
+// extract writes the database contained in the first size bytes of the
+// downloaded file r to tmpFile using format, failing with
+// ErrDatabaseTooLarge beyond maxSize bytes, and returns the notices found
+// next to it. If checksum is set, the file must match it or
+// ErrChecksumMismatch is returned. tmpFile is synced to disk; on error it
+// is removed.
+func extract(r io.ReaderAt, size int64, format ArchiveFormat, checksum, tmpFile string, maxSize int64) (notices map[string][]byte, err error) {
+	if checksum != "" {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, io.NewSectionReader(r, 0, size)); err != nil {
+			return nil, fmt.Errorf("read archive: %w", err)
+		}
+		if sum := hex.EncodeToString(hash.Sum(nil)); sum != checksum {
+			return nil, fmt.Errorf("%w: got %s, want %s", ErrChecksumMismatch, sum, checksum)
+		}
 	}
-	defer gz.Close()
 
-	tr := tar.NewReader(gz)
 	out, err := os.Create(tmpFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
-		out.Close()
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
 		if err != nil {
 			os.Remove(tmpFile)
 		}
 	}()
 
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("tar read: %w", err)
-		}
-		if hdr.Typeflag == tar.TypeReg && strings.HasSuffix(hdr.Name, ".mmdb") {
-			if _, err := io.Copy(out, tr); err != nil {
-				return fmt.Errorf("extract mmdb: %w", err)
-			}
-			return nil
-		}
+	w := &extractWriter{w: out, max: maxSize}
+	if err := format.Extract(r, size, w); err != nil {
+		return nil, err
 	}
-	return fmt.Errorf("no .mmdb entry found in archive")
+	return w.notices, out.Sync()
+}
+
+// noticePath returns where the notice name of edition is installed, e.g.
+// GeoLite2-City.LICENSE.txt.
+func noticePath(dir, edition, name string) string {
+	return path.Join(dir, edition+"."+name)
 }
 
-func backupOld(path string) error {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return nil
+// installNotices writes the license and copyright notices of the installed
+// database of edition next to it. Failures are logged, as the database
+// itself is already in place.
+func (d *Downloader) installNotices(edition string, notices map[string][]byte) {
+	for name, data := range notices {
+		dst := noticePath(d.BasePath, edition, name)
+		tmp := dst + ".tmp"
+		err := os.WriteFile(tmp, data, 0o644)
+		if err == nil {
+			err = os.Rename(tmp, dst)
+		}
+		if err != nil {
+			os.Remove(tmp)
+			log.Printf("mmdb [%s] install %s: %v", edition, name, err)
+		}
 	}
-	bak := path + ".old"
-	os.Remove(bak)
-	return os.Rename(path, bak)
 }
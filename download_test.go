@@ -0,0 +1,766 @@
+package mmdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NoUmlautsAllowed/go-mmdb/mmdbtest"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const (
+	testAccountID  = "123456"
+	testLicenseKey = "test_license_key"
+)
+
+var testModTime = time.Date(2023, 11, 14, 12, 0, 0, 0, time.UTC)
+
+// testRetryPolicy retries quickly; newTestDownloader also replaces the
+// sleep so that no test actually waits.
+var testRetryPolicy = RetryPolicy{MaxAttempts: 3, MinBackoff: time.Second, MaxBackoff: time.Minute}
+
+// newTestDownloader starts a fake MaxMind server publishing City, Country
+// and ASN and returns a Downloader for it writing into a temporary directory.
+func newTestDownloader(t testing.TB, opts ...Option) (*Downloader, *mmdbtest.Server) {
+	t.Helper()
+
+	srv := mmdbtest.NewServer(testAccountID, testLicenseKey)
+	t.Cleanup(srv.Close)
+	for _, edition := range []string{CityDatabase, CountryDatabase, ASNDatabase} {
+		srv.SetDatabase(edition, mmdbtest.DefaultDatabase(edition), testModTime)
+	}
+
+	t.Setenv(MaxmindAccountId, testAccountID)
+	t.Setenv(MaxmindLicenseKey, testLicenseKey)
+	t.Setenv(MaxmindBasePath, t.TempDir())
+	t.Setenv(MaxmindEditionIds, "")
+	t.Setenv(MaxmindBackupRetention, "")
+	t.Setenv(MaxmindPinnedGenerations, "")
+	t.Setenv(MaxmindSource, "")
+	t.Setenv(MaxmindSchedule, "")
+	t.Setenv(MaxmindStartupJitter, "")
+	t.Setenv(MaxmindLock, "")
+	t.Setenv(MaxmindAccountIdFile, "")
+	t.Setenv(MaxmindLicenseKeyFile, "")
+	t.Setenv(MaxmindGeoIPConf, "")
+	for _, name := range []string{MaxmindProxy, MaxmindCACerts, MaxmindClientCert, MaxmindClientKey, MaxmindUserAgent} {
+		t.Setenv(name, "")
+	}
+
+	opts = append([]Option{
+		WithURL(srv.DownloadURL()),
+		WithClient(srv.Client()),
+		WithRetryPolicy(testRetryPolicy),
+	}, opts...)
+	d, err := NewDownloader(opts...)
+	if err != nil {
+		t.Fatalf("NewDownloader: %v", err)
+	}
+	d.sleep = func(ctx context.Context, _ time.Duration) error { return ctx.Err() }
+	return d, srv
+}
+
+// archiveDownloads counts the archive (not checksum) downloads of an edition
+// that were answered with a body.
+func archiveDownloads(srv *mmdbtest.Server, edition string) int {
+	n := 0
+	for _, r := range srv.Requests() {
+		if r.Method == http.MethodGet && r.Edition == edition && r.Suffix == "tar.gz" && r.Status == http.StatusOK {
+			n++
+		}
+	}
+	return n
+}
+
+func downloadCount(db, status string) float64 {
+	return testutil.ToFloat64(DownloadTotal.WithLabelValues(db, status))
+}
+
+func TestNewDownloader(t *testing.T) {
+	tests := []struct {
+		name     string
+		account  string
+		license  string
+		basePath string
+		wantErr  bool
+		wantBase string
+	}{
+		{name: "Configured", account: "1", license: "key", basePath: "/data", wantBase: "/data"},
+		{name: "Default Base Path", account: "1", license: "key", wantBase: "."},
+		{name: "Missing Account", license: "key", wantErr: true},
+		{name: "Missing License", account: "1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(MaxmindAccountId, tt.account)
+			t.Setenv(MaxmindLicenseKey, tt.license)
+			t.Setenv(MaxmindBasePath, tt.basePath)
+
+			d, err := NewDownloader()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewDownloader: %v", err)
+			}
+			if d.BasePath != tt.wantBase {
+				t.Errorf("expected base path %q, got %q", tt.wantBase, d.BasePath)
+			}
+		})
+	}
+}
+
+func TestNewDownloaderEditions(t *testing.T) {
+	t.Setenv(MaxmindAccountId, testAccountID)
+	t.Setenv(MaxmindLicenseKey, testLicenseKey)
+
+	t.Setenv(MaxmindEditionIds, "")
+	d, err := NewDownloader()
+	if err != nil {
+		t.Fatalf("NewDownloader: %v", err)
+	}
+	if !reflect.DeepEqual(d.Editions, DefaultEditions) {
+		t.Errorf("expected default editions, got %v", d.Editions)
+	}
+
+	t.Setenv(MaxmindEditionIds, "GeoLite2-ASN,GeoIP2-City")
+	d, err = NewDownloader()
+	if err != nil {
+		t.Fatalf("NewDownloader: %v", err)
+	}
+	if expected := []string{ASNDatabase, "GeoIP2-City"}; !reflect.DeepEqual(d.Editions, expected) {
+		t.Errorf("expected %v, got %v", expected, d.Editions)
+	}
+
+	d, err = NewDownloader(WithEditions(CountryDatabase))
+	if err != nil {
+		t.Fatalf("NewDownloader: %v", err)
+	}
+	if expected := []string{CountryDatabase}; !reflect.DeepEqual(d.Editions, expected) {
+		t.Errorf("expected option to win, got %v", d.Editions)
+	}
+
+	if _, err := NewDownloader(WithEditions("GeoLite2-Contry")); err == nil {
+		t.Error("expected error for unknown edition option")
+	}
+
+	t.Setenv(MaxmindEditionIds, "GeoLite2-Citi")
+	if _, err := NewDownloader(); err == nil || !strings.Contains(err.Error(), MaxmindEditionIds) {
+		t.Errorf("expected error naming %s, got %v", MaxmindEditionIds, err)
+	}
+
+	// the variable is not read if an option chose the editions
+	d, err = NewDownloader(WithEditions(CountryDatabase))
+	if err != nil {
+		t.Fatalf("expected option to ignore invalid %s, got %v", MaxmindEditionIds, err)
+	}
+	if expected := []string{CountryDatabase}; !reflect.DeepEqual(d.Editions, expected) {
+		t.Errorf("expected %v, got %v", expected, d.Editions)
+	}
+}
+
+func TestDownloadOne(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	before := downloadCount(CityDatabase, "success")
+
+	if err := d.downloadOne(context.Background(), CityDatabase).Err; err != nil {
+		t.Fatalf("downloadOne: %v", err)
+	}
+
+	path := dbPath(d.BasePath, CityDatabase)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("expected database file: %v", err)
+	}
+	if !info.ModTime().Equal(testModTime) {
+		t.Errorf("expected mtime %s, got %s", testModTime, info.ModTime().UTC())
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected no temp file, got %v", err)
+	}
+
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		t.Fatalf("open downloaded database: %v", err)
+	}
+	defer reader.Close()
+	if reader.Metadata.DatabaseType != CityDatabase {
+		t.Errorf("expected database type %q, got %q", CityDatabase, reader.Metadata.DatabaseType)
+	}
+
+	if got := downloadCount(CityDatabase, "success") - before; got != 1 {
+		t.Errorf("expected success counter to increase by 1, got %v", got)
+	}
+	if n := archiveDownloads(srv, CityDatabase); n != 1 {
+		t.Errorf("expected 1 archive download, got %d", n)
+	}
+	if n := srv.RequestCount(http.MethodGet, CityDatabase); n != 2 {
+		t.Errorf("expected archive and checksum GET requests, got %d", n)
+	}
+
+	req := srv.Requests()[0]
+	if req.Method != http.MethodGet || req.Suffix != "tar.gz" {
+		t.Errorf("expected GET for tar.gz first, got %s %s", req.Method, req.Suffix)
+	}
+	if req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		t.Errorf("expected unconditional request without local database, got %v", req.Header)
+	}
+
+	st := loadState(d.BasePath, CityDatabase)
+	if st.BuildEpoch != mmdbtest.BuildEpoch || st.ETag == "" || st.SHA256 == "" || !st.lastModified().Equal(testModTime) {
+		t.Errorf("expected state to describe the downloaded release, got %+v", st)
+	}
+}
+
+func TestDownloadOneUpToDate(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	ctx := context.Background()
+
+	if err := d.downloadOne(ctx, ASNDatabase).Err; err != nil {
+		t.Fatalf("first download: %v", err)
+	}
+	before := downloadCount(ASNDatabase, "skipped")
+
+	if err := d.downloadOne(ctx, ASNDatabase).Err; err != nil {
+		t.Fatalf("second download: %v", err)
+	}
+
+	if n := archiveDownloads(srv, ASNDatabase); n != 1 {
+		t.Errorf("expected up-to-date database not to be fetched again, got %d GET requests", n)
+	}
+	if got := downloadCount(ASNDatabase, "skipped") - before; got != 1 {
+		t.Errorf("expected skipped counter to increase by 1, got %v", got)
+	}
+	if gens, _ := backups(d.BasePath, ASNDatabase); len(gens) != 0 {
+		t.Errorf("expected no backup for skipped download, got %v", gens)
+	}
+
+	reqs := srv.Requests()
+	last := reqs[len(reqs)-1]
+	if last.Status != http.StatusNotModified || last.Header.Get("If-None-Match") == "" || last.Header.Get("If-Modified-Since") == "" {
+		t.Errorf("expected conditional GET answered with 304, got %d with %v", last.Status, last.Header)
+	}
+}
+
+// ignoreValidators drops the conditional request headers, like a server
+// that ignores them and always answers 200.
+type ignoreValidators struct{}
+
+func (ignoreValidators) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestDownloadOneSameRelease(t *testing.T) {
+	d, srv := newTestDownloader(t, WithClient(&http.Client{Transport: ignoreValidators{}}))
+	ctx := context.Background()
+
+	if err := d.downloadOne(ctx, CityDatabase).Err; err != nil {
+		t.Fatalf("first download: %v", err)
+	}
+	path := dbPath(d.BasePath, CityDatabase)
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := d.downloadOne(ctx, CityDatabase)
+	if res.Err != nil || res.Status != StatusSkipped {
+		t.Fatalf("expected the same release to be skipped, got %s: %v", res.Status, res.Err)
+	}
+	if n := archiveDownloads(srv, CityDatabase); n != 2 {
+		t.Errorf("expected the release to be fetched twice, got %d", n)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("expected the installed database to be kept")
+	}
+}
+
+func TestDownloadOneIgnoresFileTimes(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	ctx := context.Background()
+
+	if err := d.downloadOne(ctx, CityDatabase).Err; err != nil {
+		t.Fatalf("first download: %v", err)
+	}
+
+	// a copied file gets a fresh mtime that is newer than the next release
+	path := dbPath(d.BasePath, CityDatabase)
+	future := time.Now().Add(24 * time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	newData, err := mmdbtest.Database(CityDatabase, mmdbtest.BuildEpoch+3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetDatabase(CityDatabase, newData, testModTime.Add(time.Hour))
+
+	res := d.downloadOne(ctx, CityDatabase)
+	if res.Err != nil || res.Status != StatusSuccess {
+		t.Fatalf("expected newer release to be downloaded, got %s: %v", res.Status, res.Err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, newData) {
+		t.Error("expected database to be replaced by the newer release")
+	}
+}
+
+func TestDownloadOneRestoredFromBackup(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	ctx := context.Background()
+
+	if err := d.downloadOne(ctx, CityDatabase).Err; err != nil {
+		t.Fatalf("first download: %v", err)
+	}
+	newData, err := mmdbtest.Database(CityDatabase, mmdbtest.BuildEpoch+3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetDatabase(CityDatabase, newData, testModTime.Add(time.Hour))
+	if err := d.downloadOne(ctx, CityDatabase).Err; err != nil {
+		t.Fatalf("second download: %v", err)
+	}
+
+	// restoring the backup leaves a state file describing the newer release
+	path := dbPath(d.BasePath, CityDatabase)
+	if err := os.Rename(generationPath(d.BasePath, CityDatabase, testBuildEpoch), path); err != nil {
+		t.Fatal(err)
+	}
+	if st := loadState(d.BasePath, CityDatabase); st != (editionState{}) {
+		t.Errorf("expected state of a different build to be ignored, got %+v", st)
+	}
+
+	res := d.downloadOne(ctx, CityDatabase)
+	if res.Err != nil || res.Status != StatusSuccess {
+		t.Fatalf("expected restored database to be replaced, got %s: %v", res.Status, res.Err)
+	}
+	epoch, err := buildEpoch(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if epoch != mmdbtest.BuildEpoch+3600 {
+		t.Errorf("expected build epoch %d, got %d", mmdbtest.BuildEpoch+3600, epoch)
+	}
+}
+
+func TestDownloadOneCorruptState(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	ctx := context.Background()
+
+	if err := d.downloadOne(ctx, ASNDatabase).Err; err != nil {
+		t.Fatalf("first download: %v", err)
+	}
+	if err := os.WriteFile(statePath(d.BasePath, ASNDatabase), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if res := d.downloadOne(ctx, ASNDatabase); res.Err != nil || res.Status != StatusSuccess {
+		t.Fatalf("expected unconditional download, got %s: %v", res.Status, res.Err)
+	}
+	if n := archiveDownloads(srv, ASNDatabase); n != 2 {
+		t.Errorf("expected 2 archive downloads, got %d", n)
+	}
+	if st := loadState(d.BasePath, ASNDatabase); st.ETag == "" {
+		t.Errorf("expected state to be rewritten, got %+v", st)
+	}
+}
+
+func TestDownloadOneInvalidDatabase(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	archive, err := mmdbtest.Archive(CityDatabase, testModTime, map[string][]byte{
+		CityDatabase + ".mmdb": []byte("not a database"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetArchive(CityDatabase, archive, testModTime)
+
+	if err := d.downloadOne(context.Background(), CityDatabase).Err; err == nil {
+		t.Fatal("expected error for invalid database, got nil")
+	}
+	path := dbPath(d.BasePath, CityDatabase)
+	for _, p := range []string{path, path + ".tmp", statePath(d.BasePath, CityDatabase)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to exist, got %v", filepath.Base(p), err)
+		}
+	}
+}
+
+func TestDownloadOneNewerRelease(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	ctx := context.Background()
+
+	if err := d.downloadOne(ctx, CountryDatabase).Err; err != nil {
+		t.Fatalf("first download: %v", err)
+	}
+	path := dbPath(d.BasePath, CountryDatabase)
+	oldData, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newModTime := testModTime.Add(72 * time.Hour)
+	newData, err := mmdbtest.Database(CountryDatabase, mmdbtest.BuildEpoch+72*3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetDatabase(CountryDatabase, newData, newModTime)
+
+	if err := d.downloadOne(ctx, CountryDatabase).Err; err != nil {
+		t.Fatalf("second download: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, newData) {
+		t.Error("expected database to be replaced by the newer release")
+	}
+	backup, err := os.ReadFile(generationPath(d.BasePath, CountryDatabase, testBuildEpoch))
+	if err != nil {
+		t.Fatalf("expected backup of the previous release: %v", err)
+	}
+	if !bytes.Equal(backup, oldData) {
+		t.Error("expected backup to contain the previous release")
+	}
+}
+
+func TestDownloadOneErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		edition string
+		license string
+		fail    []int
+	}{
+		{name: "Invalid Credentials", edition: CityDatabase, license: "wrong"},
+		{name: "Rate Limited", edition: CityDatabase, fail: []int{429, 429, 429}},
+		{name: "Server Error On Archive", edition: CityDatabase, fail: []int{500, 500, 500}},
+		{name: "Server Error On Checksum", edition: CityDatabase, fail: []int{0, 502, 502, 502}},
+		{name: "Unknown Edition", edition: "GeoLite2-Nope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, srv := newTestDownloader(t)
+			if tt.license != "" {
+				d.LicenseKey = tt.license
+			}
+			srv.FailNext(tt.fail...)
+			before := downloadCount(tt.edition, "failure")
+
+			if err := d.downloadOne(context.Background(), tt.edition).Err; err == nil {
+				t.Fatal("expected error, got nil")
+			}
+
+			path := dbPath(d.BasePath, tt.edition)
+			for _, p := range []string{path, path + ".tmp"} {
+				if _, err := os.Stat(p); !os.IsNotExist(err) {
+					t.Errorf("expected %s not to exist, got %v", filepath.Base(p), err)
+				}
+			}
+			if got := downloadCount(tt.edition, "failure") - before; got != 1 {
+				t.Errorf("expected failure counter to increase by 1, got %v", got)
+			}
+		})
+	}
+}
+
+func TestDownloadOneChecksum(t *testing.T) {
+	tests := []struct {
+		name     string
+		checksum string
+		status   string
+		mismatch bool
+	}{
+		{name: "Mismatch", checksum: strings.Repeat("0", 64), status: "checksum_mismatch", mismatch: true},
+		{name: "Malformed", checksum: "not-a-checksum", status: "failure"},
+		{name: "Empty", checksum: "", status: "failure"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, srv := newTestDownloader(t)
+			srv.SetChecksum(CityDatabase, tt.checksum)
+			before := downloadCount(CityDatabase, tt.status)
+
+			err := d.downloadOne(context.Background(), CityDatabase).Err
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if errors.Is(err, ErrChecksumMismatch) != tt.mismatch {
+				t.Errorf("expected ErrChecksumMismatch = %v, got %v", tt.mismatch, err)
+			}
+
+			path := dbPath(d.BasePath, CityDatabase)
+			for _, p := range []string{path, path + ".tmp"} {
+				if _, err := os.Stat(p); !os.IsNotExist(err) {
+					t.Errorf("expected %s not to exist, got %v", filepath.Base(p), err)
+				}
+			}
+			if got := downloadCount(CityDatabase, tt.status) - before; got != 1 {
+				t.Errorf("expected %s counter to increase by 1, got %v", tt.status, got)
+			}
+		})
+	}
+}
+
+func TestDownloadOneChecksumKeepsExisting(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	ctx := context.Background()
+
+	if err := d.downloadOne(ctx, CityDatabase).Err; err != nil {
+		t.Fatalf("first download: %v", err)
+	}
+	path := dbPath(d.BasePath, CityDatabase)
+	oldData, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.SetDatabase(CityDatabase, mmdbtest.DefaultDatabase(ASNDatabase), testModTime.Add(time.Hour))
+	srv.SetChecksum(CityDatabase, strings.Repeat("f", 64))
+
+	if err := d.downloadOne(ctx, CityDatabase).Err; !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, oldData) {
+		t.Error("expected existing database to be kept on checksum mismatch")
+	}
+	if gens, _ := backups(d.BasePath, CityDatabase); len(gens) != 0 {
+		t.Errorf("expected no backup on checksum mismatch, got %v", gens)
+	}
+}
+
+func TestChecksumURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{
+			url:      "https://download.maxmind.com/geoip/databases/GeoLite2-City/download?suffix=tar.gz",
+			expected: "https://download.maxmind.com/geoip/databases/GeoLite2-City/download?suffix=tar.gz.sha256",
+		},
+		{
+			url:      "https://mirror.example.com/GeoLite2-City.tar.gz",
+			expected: "https://mirror.example.com/GeoLite2-City.tar.gz.sha256",
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := checksumURL(tt.url)
+		if err != nil {
+			t.Fatalf("checksumURL(%q): %v", tt.url, err)
+		}
+		if got != tt.expected {
+			t.Errorf("checksumURL(%q): expected %q, got %q", tt.url, tt.expected, got)
+		}
+	}
+}
+
+func TestDownloadDatabases(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	d.BasePath = filepath.Join(d.BasePath, "nested", "dir")
+
+	report, err := d.DownloadDatabases(context.Background(), CityDatabase, "GeoLite2-Nope", ASNDatabase)
+	if err == nil || !strings.Contains(err.Error(), "GeoLite2-Nope: ") {
+		t.Fatalf("expected error naming the failed edition, got %v", err)
+	}
+	if strings.Contains(err.Error(), CityDatabase) || strings.Contains(err.Error(), ASNDatabase) {
+		t.Errorf("expected error to only contain failures, got %v", err)
+	}
+
+	if len(report.Results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(report.Results))
+	}
+	expected := []struct {
+		edition string
+		status  DownloadStatus
+	}{
+		{CityDatabase, StatusSuccess},
+		{"GeoLite2-Nope", StatusFailure},
+		{ASNDatabase, StatusSuccess},
+	}
+	for i, want := range expected {
+		res := report.Results[i]
+		if res.Edition != want.edition || res.Status != want.status {
+			t.Errorf("result %d: expected %s %s, got %s %s", i, want.edition, want.status, res.Edition, res.Status)
+		}
+	}
+	city := report.Results[0]
+	if city.Bytes == 0 || !city.RemoteTime.Equal(testModTime) || city.Duration <= 0 || city.Err != nil {
+		t.Errorf("expected complete city result, got %+v", city)
+	}
+	if failed := report.Failed(); len(failed) != 1 || failed[0].Edition != "GeoLite2-Nope" {
+		t.Errorf("expected only GeoLite2-Nope to fail, got %+v", failed)
+	}
+
+	for _, edition := range []string{CityDatabase, ASNDatabase} {
+		if _, err := os.Stat(dbPath(d.BasePath, edition)); err != nil {
+			t.Errorf("expected %s to be downloaded: %v", edition, err)
+		}
+	}
+	if n := srv.RequestCount(http.MethodGet, CountryDatabase); n != 0 {
+		t.Errorf("expected unrequested edition not to be fetched, got %d requests", n)
+	}
+}
+
+func TestDownloadDatabasesConfiguredEditions(t *testing.T) {
+	d, srv := newTestDownloader(t, WithEditions(CountryDatabase))
+
+	if _, err := d.DownloadDatabases(context.Background()); err != nil {
+		t.Fatalf("DownloadDatabases: %v", err)
+	}
+	if _, err := os.Stat(dbPath(d.BasePath, CountryDatabase)); err != nil {
+		t.Errorf("expected configured edition to be downloaded: %v", err)
+	}
+	for _, edition := range []string{CityDatabase, ASNDatabase} {
+		if n := srv.RequestCount(http.MethodGet, edition); n != 0 {
+			t.Errorf("expected %s not to be fetched, got %d requests", edition, n)
+		}
+	}
+}
+
+func TestDownloadDatabasesDuplicates(t *testing.T) {
+	d, srv := newTestDownloader(t, WithLockMode(LockSkip))
+
+	report, err := d.DownloadDatabases(context.Background(), CityDatabase, ASNDatabase, CityDatabase)
+	if err != nil {
+		t.Fatalf("DownloadDatabases: %v", err)
+	}
+	if len(report.Results) != 2 {
+		t.Fatalf("expected one result per edition, got %+v", report.Results)
+	}
+	for i, want := range []string{CityDatabase, ASNDatabase} {
+		if res := report.Results[i]; res.Edition != want || res.Status != StatusSuccess {
+			t.Errorf("result %d: expected %s %s, got %s %s", i, want, StatusSuccess, res.Edition, res.Status)
+		}
+	}
+	if n := archiveDownloads(srv, CityDatabase); n != 1 {
+		t.Errorf("expected the duplicate edition to be downloaded once, got %d", n)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	database := mmdbtest.DefaultDatabase(CityDatabase)
+
+	tests := []struct {
+		name     string
+		archive  func() ([]byte, error)
+		checksum string
+		wantErr  bool
+	}{
+		{
+			name: "Valid Archive",
+			archive: func() ([]byte, error) {
+				return mmdbtest.Archive(CityDatabase, testModTime, map[string][]byte{
+					"README.txt":           []byte("readme"),
+					CityDatabase + ".mmdb": database,
+				})
+			},
+		},
+		{
+			name: "No MMDB Entry",
+			archive: func() ([]byte, error) {
+				return mmdbtest.Archive(CityDatabase, testModTime, map[string][]byte{
+					"LICENSE.txt": []byte("license"),
+				})
+			},
+			wantErr: true,
+		},
+		{
+			name: "Not Gzip",
+			archive: func() ([]byte, error) {
+				return []byte("plain text"), nil
+			},
+			wantErr: true,
+		},
+		{
+			name: "Checksum Mismatch",
+			archive: func() ([]byte, error) {
+				return mmdbtest.Archive(CityDatabase, testModTime, map[string][]byte{
+					CityDatabase + ".mmdb": database,
+				})
+			},
+			checksum: strings.Repeat("0", 64),
+			wantErr:  true,
+		},
+		{
+			name: "Truncated Archive",
+			archive: func() ([]byte, error) {
+				archive, err := mmdbtest.Archive(CityDatabase, testModTime, map[string][]byte{
+					CityDatabase + ".mmdb": database,
+				})
+				return archive[:len(archive)/2], err
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, err := tt.archive()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checksum := tt.checksum
+			if checksum == "" {
+				sum := sha256.Sum256(archive)
+				checksum = hex.EncodeToString(sum[:])
+			}
+
+			tmp := filepath.Join(t.TempDir(), "out.tmp")
+			_, err = extract(bytes.NewReader(archive), int64(len(archive)), FormatGzip, checksum, tmp, DefaultMaxDatabaseSize)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+					t.Errorf("expected temp file to be removed, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			got, err := os.ReadFile(tmp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, database) {
+				t.Error("expected extracted file to equal the archived database")
+			}
+		})
+	}
+}
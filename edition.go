@@ -0,0 +1,156 @@
+package mmdb
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// editionKind describes which part of IPInfo an edition can answer.
+type editionKind int
+
+const (
+	kindOther editionKind = iota
+	kindCity
+	kindCountry
+	kindASN
+)
+
+// knownEditions are the MMDB edition IDs published by MaxMind.
+var knownEditions = map[string]editionKind{
+	CityDatabase:                  kindCity,
+	CountryDatabase:               kindCountry,
+	ASNDatabase:                   kindASN,
+	"GeoIP2-City":                 kindCity,
+	"GeoIP2-City-Africa":          kindCity,
+	"GeoIP2-City-Asia-Pacific":    kindCity,
+	"GeoIP2-City-Europe":          kindCity,
+	"GeoIP2-City-North-America":   kindCity,
+	"GeoIP2-City-South-America":   kindCity,
+	"GeoIP2-City-Shield":          kindCity,
+	"GeoIP2-Country":              kindCountry,
+	"GeoIP2-Country-Shield":       kindCountry,
+	"GeoIP2-Enterprise":           kindCity,
+	"GeoIP2-Enterprise-Shield":    kindCity,
+	"GeoIP2-ISP":                  kindASN,
+	"GeoIP2-Domain":               kindOther,
+	"GeoIP2-Connection-Type":      kindOther,
+	"GeoIP2-User-Connection-Type": kindOther,
+	"GeoIP2-Anonymous-IP":         kindOther,
+	"GeoIP2-Anonymous-Plus":       kindOther,
+	"GeoIP2-IP-Risk":              kindOther,
+	"GeoIP2-Static-IP-Score":      kindOther,
+	"GeoIP2-User-Count":           kindOther,
+}
+
+// DefaultEditions are used when MAXMIND_EDITION_IDS is not set.
+var DefaultEditions = []string{CityDatabase, CountryDatabase, ASNDatabase}
+
+// ParseEditions splits a comma or space separated list of edition IDs and
+// validates it. Duplicates are dropped; an empty list yields DefaultEditions.
+func ParseEditions(s string) ([]string, error) {
+	editions := SplitEditions(s)
+	if err := ValidateEditions(editions); err != nil {
+		return nil, err
+	}
+	return editions, nil
+}
+
+// SplitEditions is like ParseEditions without validating the editions, for
+// editions checked with Downloader.ValidateEditions instead.
+func SplitEditions(s string) []string {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+	if len(fields) == 0 {
+		return slices.Clone(DefaultEditions)
+	}
+	return uniqueEditions(fields)
+}
+
+// uniqueEditions returns editions without duplicates, keeping the first
+// occurrence of each.
+func uniqueEditions(editions []string) []string {
+	var unique []string
+	for _, e := range editions {
+		if !slices.Contains(unique, e) {
+			unique = append(unique, e)
+		}
+	}
+	return unique
+}
+
+// ValidateEditions returns an error naming the first edition ID not
+// published by MaxMind, with a suggestion if it looks like a typo of a
+// known one.
+func ValidateEditions(editions []string) error {
+	return validateEditions(editions, func(string) bool { return false })
+}
+
+// ValidateEditions is like the package-level ValidateEditions, but also
+// accepts the editions d can download from elsewhere: those with a URL set
+// by WithEditionURL, and any edition of a Source other than MaxMind's
+// download API, such as a mirror, a DirSource or an S3Source.
+func (d *Downloader) ValidateEditions(editions []string) error {
+	src, ok := d.source.(*httpSource)
+	api := ok && src.maxmind()
+	return validateEditions(editions, func(e string) bool {
+		_, ok := d.urls[e]
+		return ok || !api
+	})
+}
+
+// validateEditions implements ValidateEditions, accepting the editions
+// known to MaxMind and those for which extra returns true.
+func validateEditions(editions []string, extra func(string) bool) error {
+	if len(editions) == 0 {
+		return fmt.Errorf("mmdb: no editions configured")
+	}
+	for _, e := range editions {
+		if _, ok := knownEditions[e]; ok || extra(e) {
+			continue
+		}
+		if s := suggestEdition(e); s != "" {
+			return fmt.Errorf("mmdb: unknown edition %q (did you mean %q?)", e, s)
+		}
+		return fmt.Errorf("mmdb: unknown edition %q (known: %s)", e,
+			strings.Join(slices.Sorted(maps.Keys(knownEditions)), ", "))
+	}
+	return nil
+}
+
+// suggestEdition returns the known edition closest to e, or "" if none is
+// close enough to be a plausible typo.
+func suggestEdition(e string) string {
+	best, bestDist := "", 4
+	for known := range knownEditions {
+		if strings.EqualFold(known, e) {
+			return known
+		}
+		if d := levenshtein(strings.ToLower(known), strings.ToLower(e)); d < bestDist || (d == bestDist && known < best) {
+			best, bestDist = known, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
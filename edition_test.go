@@ -0,0 +1,67 @@
+package mmdb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEditions(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+		errMsg   string
+	}{
+		{name: "Empty", input: "", expected: DefaultEditions},
+		{name: "Whitespace Only", input: " , ", expected: DefaultEditions},
+		{name: "Single", input: "GeoLite2-ASN", expected: []string{ASNDatabase}},
+		{name: "Comma Separated", input: "GeoLite2-City, GeoIP2-ISP", expected: []string{CityDatabase, "GeoIP2-ISP"}},
+		{name: "Space Separated", input: "GeoLite2-Country GeoLite2-ASN", expected: []string{CountryDatabase, ASNDatabase}},
+		{name: "Regional City", input: "GeoIP2-City-Europe,GeoIP2-City-Asia-Pacific", expected: []string{"GeoIP2-City-Europe", "GeoIP2-City-Asia-Pacific"}},
+		{name: "Shield", input: "GeoIP2-City-Shield GeoIP2-Country-Shield GeoIP2-Enterprise-Shield", expected: []string{"GeoIP2-City-Shield", "GeoIP2-Country-Shield", "GeoIP2-Enterprise-Shield"}},
+		{
+			name:     "Other Products",
+			input:    "GeoIP2-Anonymous-Plus,GeoIP2-User-Connection-Type,GeoIP2-IP-Risk,GeoIP2-Static-IP-Score,GeoIP2-User-Count",
+			expected: []string{"GeoIP2-Anonymous-Plus", "GeoIP2-User-Connection-Type", "GeoIP2-IP-Risk", "GeoIP2-Static-IP-Score", "GeoIP2-User-Count"},
+		},
+		{name: "Duplicates", input: "GeoLite2-City,GeoLite2-City", expected: []string{CityDatabase}},
+		{name: "Typo", input: "GeoLite2-Cty", errMsg: `unknown edition "GeoLite2-Cty" (did you mean "GeoLite2-City"?)`},
+		{name: "Wrong Case", input: "geolite2-asn", errMsg: `did you mean "GeoLite2-ASN"?`},
+		{name: "Unknown", input: "GeoLite2-City,Something", errMsg: `unknown edition "Something" (known: `},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEditions(tt.input)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("expected error containing %q, got %v", tt.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseEditions: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestDownloaderValidateEditions(t *testing.T) {
+	d, _ := newTestDownloader(t, WithEditionURL("ipinfo-country", "https://example.com/country.mmdb"))
+	if err := d.ValidateEditions([]string{CityDatabase, "ipinfo-country"}); err != nil {
+		t.Errorf("expected edition with a URL to be valid, got %v", err)
+	}
+	if err := d.ValidateEditions([]string{"GeoLite2-Cty"}); err == nil || !strings.Contains(err.Error(), `did you mean "GeoLite2-City"?`) {
+		t.Errorf("expected typo to be reported, got %v", err)
+	}
+
+	// other sources publish whatever editions they hold
+	d, _ = newTestDownloader(t, WithSource(DirSource{Dir: t.TempDir()}))
+	if err := d.ValidateEditions([]string{"IP2LOCATION-LITE-DB1"}); err != nil {
+		t.Errorf("expected any edition of a directory source to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package mmdb
+
+// syncDir does nothing on this platform, where directories cannot be
+// synced.
+func syncDir(dir string) {}
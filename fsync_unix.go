@@ -0,0 +1,22 @@
+//go:build unix
+
+package mmdb
+
+import (
+	"log"
+	"os"
+)
+
+// syncDir flushes the entries of dir, so that files created or renamed in
+// it survive a crash. Failures are logged.
+func syncDir(dir string) {
+	f, err := os.Open(dir)
+	if err != nil {
+		log.Printf("mmdb: sync %s: %v", dir, err)
+		return
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		log.Printf("mmdb: sync %s: %v", dir, err)
+	}
+}
@@ -0,0 +1,231 @@
+package mmdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultGeoIPUpdateHost is geoipupdate's default Host. Its databases are
+// downloaded from MaxMind's download API instead.
+const defaultGeoIPUpdateHost = "updates.maxmind.com"
+
+// GeoIPConf is a GeoIP.conf file of MaxMind's geoipupdate, so that existing
+// configurations can be used as they are.
+type GeoIPConf struct {
+	// Path is the file the configuration was loaded from.
+	Path string
+
+	AccountID         string
+	LicenseKey        string
+	EditionIDs        []string
+	DatabaseDirectory string
+	// Host is the download server, with or without scheme; empty or
+	// updates.maxmind.com mean MaxMind.
+	Host string
+	// Proxy is a proxy URL or host:port, with ProxyUserPassword as
+	// "user:password" if set.
+	Proxy             string
+	ProxyUserPassword string
+	PreserveFileTimes bool
+	LockFile          string
+	RetryFor          time.Duration
+	Parallelism       int
+
+	// CACertFile, ClientCertFile, ClientKeyFile and UserAgent are go-mmdb
+	// extensions, see LoadRootCAs, WithClientCertificate and WithUserAgent.
+	CACertFile     string
+	ClientCertFile string
+	ClientKeyFile  string
+	UserAgent      string
+}
+
+// LoadGeoIPConf reads a GeoIP.conf file. Unknown settings are rejected, as
+// geoipupdate does.
+func LoadGeoIPConf(path string) (*GeoIPConf, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("mmdb: %w", err)
+	}
+	defer f.Close()
+
+	settings, err := parseGeoIPConf(f)
+	if err != nil {
+		return nil, fmt.Errorf("mmdb: %s: %w", path, err)
+	}
+
+	c := &GeoIPConf{Path: path, RetryFor: 5 * time.Minute}
+	for name, value := range settings {
+		switch name {
+		case "AccountID", "UserId":
+			c.AccountID = value
+		case "LicenseKey":
+			c.LicenseKey = value
+		case "EditionIDs", "ProductIds":
+			c.EditionIDs = strings.Fields(value)
+		case "DatabaseDirectory":
+			c.DatabaseDirectory = value
+		case "Host":
+			c.Host = value
+		case "Proxy":
+			c.Proxy = value
+		case "ProxyUserPassword":
+			c.ProxyUserPassword = value
+		case "PreserveFileTimes":
+			c.PreserveFileTimes, err = parseGeoIPConfBool(value)
+		case "LockFile":
+			c.LockFile = value
+		case "RetryFor":
+			c.RetryFor, err = time.ParseDuration(value)
+		case "Parallelism":
+			c.Parallelism, err = strconv.Atoi(value)
+		case "CACertFile":
+			c.CACertFile = value
+		case "ClientCertFile":
+			c.ClientCertFile = value
+		case "ClientKeyFile":
+			c.ClientKeyFile = value
+		case "UserAgent":
+			c.UserAgent = value
+		default:
+			err = fmt.Errorf("unknown option")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("mmdb: %s: %s: %w", path, name, err)
+		}
+	}
+	return c, nil
+}
+
+// parseGeoIPConf reads the "Name value" lines of a GeoIP.conf file,
+// skipping blank lines and # comments.
+func parseGeoIPConf(r io.Reader) (map[string]string, error) {
+	settings := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing value for %s", n, line)
+		}
+		settings[line[:i]] = strings.TrimSpace(line[i+1:])
+	}
+	return settings, sc.Err()
+}
+
+func parseGeoIPConfBool(s string) (bool, error) {
+	switch s {
+	case "1":
+		return true, nil
+	case "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected 0 or 1, got %q", s)
+}
+
+// Options returns the Downloader options for the configuration. The
+// credentials are read from the file again before each run, with
+// DefaultCredentials filling in what it leaves out.
+func (c *GeoIPConf) Options() ([]Option, error) {
+	opts := []Option{
+		WithCredentials(CredentialChain{GeoIPConfCredentials{Path: c.Path}, DefaultCredentials()}),
+		WithPreserveFileTimes(c.PreserveFileTimes),
+	}
+	if len(c.EditionIDs) > 0 {
+		opts = append(opts, WithEditions(c.EditionIDs...))
+	}
+	if c.DatabaseDirectory != "" {
+		opts = append(opts, WithBasePath(c.DatabaseDirectory))
+	}
+	if c.Host != "" {
+		host := c.Host
+		if !strings.Contains(host, "://") {
+			host = "https://" + host
+		}
+		u, err := neturl.Parse(host)
+		if err != nil {
+			return nil, fmt.Errorf("mmdb: Host: %w", err)
+		}
+		if u.Host != defaultGeoIPUpdateHost {
+			opts = append(opts, WithURL(u.Scheme+"://"+u.Host+"/geoip/databases/%s/download?suffix=tar.gz"))
+		}
+	}
+	if c.Proxy != "" {
+		u, err := parseProxy(c.Proxy, c.ProxyUserPassword)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithProxy(u))
+	}
+	if c.CACertFile != "" {
+		pool, err := LoadRootCAs(c.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithRootCAs(pool))
+	}
+	if c.ClientCertFile != "" {
+		cert, err := loadClientCertificate(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithClientCertificate(cert))
+	}
+	if c.UserAgent != "" {
+		opts = append(opts, WithUserAgent(c.UserAgent))
+	}
+	if c.LockFile != "" {
+		opts = append(opts, WithLockFile(c.LockFile))
+	}
+
+	// geoipupdate retries for a duration rather than a number of attempts
+	retry := DefaultRetryPolicy
+	retry.MaxElapsed = c.RetryFor
+	retry.MaxAttempts = 1
+	if c.RetryFor > 0 {
+		retry.MaxAttempts = math.MaxInt
+	}
+	opts = append(opts, WithRetryPolicy(retry))
+
+	if c.Parallelism > 0 {
+		opts = append(opts, WithConcurrency(c.Parallelism))
+	}
+	return opts, nil
+}
+
+// parseProxy parses a geoipupdate proxy, a URL or host:port defaulting to
+// HTTP, adding userPassword as "user:password" if set.
+func parseProxy(proxy, userPassword string) (*neturl.URL, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := neturl.Parse(proxy)
+	if err != nil {
+		// the URL error would repeat a password in the proxy
+		var ue *neturl.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return nil, fmt.Errorf("mmdb: invalid proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("mmdb: unsupported proxy scheme %q", u.Scheme)
+	}
+	if userPassword != "" {
+		user, password, _ := strings.Cut(userPassword, ":")
+		u.User = neturl.UserPassword(user, password)
+	}
+	return u, nil
+}
@@ -0,0 +1,79 @@
+package mmdb
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// DatabaseInfo describes a database file: its metadata and the number of
+// networks in its search tree.
+type DatabaseInfo struct {
+	DatabaseType string            `json:"database_type"`
+	Description  map[string]string `json:"description,omitempty"`
+	Languages    []string          `json:"languages"`
+	// BinaryFormat is the MaxMind DB format version, e.g. "2.0".
+	BinaryFormat string    `json:"binary_format"`
+	BuildEpoch   uint      `json:"build_epoch"`
+	BuildTime    time.Time `json:"build_time"`
+	IPVersion    uint      `json:"ip_version"`
+	NodeCount    uint      `json:"node_count"`
+	// RecordSize is the size of a search tree record in bits.
+	RecordSize uint `json:"record_size"`
+	// TreeSize is the size of the search tree in bytes.
+	TreeSize     uint `json:"tree_size"`
+	IPv4Networks int  `json:"ipv4_networks"`
+	IPv6Networks int  `json:"ipv6_networks"`
+}
+
+// InspectDatabase reads the metadata of r and counts its networks by IP
+// version, walking the whole search tree. IPv4 networks aliased into the
+// IPv6 tree of a database are counted once, as IPv4.
+func InspectDatabase(r *maxminddb.Reader) (*DatabaseInfo, error) {
+	m := r.Metadata
+	info := &DatabaseInfo{
+		DatabaseType: m.DatabaseType,
+		Description:  m.Description,
+		Languages:    m.Languages,
+		BinaryFormat: fmt.Sprintf("%d.%d", m.BinaryFormatMajorVersion, m.BinaryFormatMinorVersion),
+		BuildEpoch:   m.BuildEpoch,
+		BuildTime:    time.Unix(int64(m.BuildEpoch), 0).UTC(),
+		IPVersion:    m.IPVersion,
+		NodeCount:    m.NodeCount,
+		RecordSize:   m.RecordSize,
+		TreeSize:     m.NodeCount * m.RecordSize / 4,
+	}
+
+	err := walkNetworks(r, func(network *net.IPNet) error {
+		if len(network.IP) == net.IPv4len {
+			info.IPv4Networks++
+		} else {
+			info.IPv6Networks++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// walkNetworks calls fn with each network of r in address order, skipping
+// the aliases of the IPv4 subtree. IPv4 networks have 4-byte addresses.
+func walkNetworks(r *maxminddb.Reader, fn func(*net.IPNet) error) error {
+	networks := r.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		// the data is read separately, only when needed
+		var skip struct{}
+		network, err := networks.Network(&skip)
+		if err != nil {
+			return err
+		}
+		if err := fn(network); err != nil {
+			return err
+		}
+	}
+	return networks.Err()
+}
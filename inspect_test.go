@@ -0,0 +1,61 @@
+package mmdb
+
+import (
+	"testing"
+
+	"github.com/NoUmlautsAllowed/go-mmdb/mmdbtest"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+func openTestDatabase(t testing.TB, edition string, records []mmdbtest.Record) *maxminddb.Reader {
+	t.Helper()
+	data, err := mmdbtest.DatabaseFrom(edition, mmdbtest.BuildEpoch, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := maxminddb.FromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestInspectDatabase(t *testing.T) {
+	tests := []struct {
+		edition  string
+		ipv4     int
+		ipv6     int
+		expected string
+	}{
+		{CityDatabase, 2, 1, CityDatabase},
+		{ASNDatabase, 2, 1, ASNDatabase},
+		{CountryDatabase, 2, 1, CountryDatabase},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.edition, func(t *testing.T) {
+			info, err := InspectDatabase(openTestDatabase(t, tt.edition, mmdbtest.Records))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.DatabaseType != tt.expected {
+				t.Errorf("expected type %s, got %s", tt.expected, info.DatabaseType)
+			}
+			if info.IPv4Networks != tt.ipv4 || info.IPv6Networks != tt.ipv6 {
+				t.Errorf("expected %d IPv4 and %d IPv6 networks, got %d and %d", tt.ipv4, tt.ipv6, info.IPv4Networks, info.IPv6Networks)
+			}
+			if info.BuildEpoch != mmdbtest.BuildEpoch || info.BuildTime.Unix() != mmdbtest.BuildEpoch {
+				t.Errorf("expected build epoch %d, got %d (%s)", mmdbtest.BuildEpoch, info.BuildEpoch, info.BuildTime)
+			}
+			if info.IPVersion != 6 || info.RecordSize != 24 || info.BinaryFormat != "2.0" {
+				t.Errorf("expected IPv6 tree with 24 bit records in format 2.0, got %+v", info)
+			}
+			if info.NodeCount == 0 || info.TreeSize != info.NodeCount*6 {
+				t.Errorf("expected tree of %d nodes to take 6 bytes each, got %d", info.NodeCount, info.TreeSize)
+			}
+			if len(info.Languages) != 1 || info.Languages[0] != "en" {
+				t.Errorf("expected languages [en], got %v", info.Languages)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Package serve runs the HTTP server of cmd/server, shared with the serve
+// command of cmd/downloader.
+package serve
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/NoUmlautsAllowed/go-mmdb"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Config holds the addresses and the token of the server.
+type Config struct {
+	// Addr is the address of the IP info server.
+	Addr string
+	// MetricsAddr is the address of the Prometheus metrics server.
+	MetricsAddr string
+	// Authorization is the Bearer token required by the server, if set.
+	Authorization string
+	// DataDirectory is the directory of the databases, served in mirror
+	// mode.
+	DataDirectory string
+}
+
+// ConfigFromEnv reads BIND_ADDR, METRICS_ADDR, AUTHORIZATION and
+// MAXMIND_BASE_PATH.
+func ConfigFromEnv() Config {
+	c := Config{
+		Addr:          os.Getenv("BIND_ADDR"),
+		MetricsAddr:   os.Getenv("METRICS_ADDR"),
+		Authorization: os.Getenv("AUTHORIZATION"),
+		DataDirectory: os.Getenv(mmdb.MaxmindBasePath),
+	}
+	if c.Addr == "" {
+		c.Addr = "localhost:8080"
+	}
+	if c.MetricsAddr == "" {
+		c.MetricsAddr = "localhost:9090"
+	}
+	if c.DataDirectory == "" {
+		c.DataDirectory = "."
+	}
+	return c
+}
+
+// Run serves IP info from the databases opened with clientOpts until ctx is
+// done or the server fails. If dl is not nil, it downloads missing
+// databases first and then keeps them up to date in the background,
+// reloading the client after each update. Mirror mode serves
+// cfg.DataDirectory with the credentials from the environment, see
+// mmdb.MirrorDirFromEnv.
+func Run(ctx context.Context, cfg Config, dl *mmdb.Downloader, clientOpts ...mmdb.ClientOption) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	client, err := mmdb.NewClient(clientOpts...)
+	downloaded := false
+	if err != nil && dl != nil {
+		// No databases yet: download them before serving
+		log.Printf("Running initial MMDB download...")
+		if _, err := dl.DownloadDatabases(ctx); err != nil {
+			log.Printf("Initial MMDB download failed: %v", err)
+		} else {
+			downloaded = true
+		}
+		client, err = mmdb.NewClient(clientOpts...)
+	}
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Background downloader, reloading the client after each update; the
+	// databases just downloaded are only checked again on schedule
+	if dl != nil {
+		dl.AddListener(client)
+		if downloaded {
+			go dl.RunScheduled(ctx)
+		} else {
+			go dl.Run(ctx)
+		}
+	}
+
+	srv, err := mmdb.NewServer(client, cfg.Authorization)
+	if err != nil {
+		return err
+	}
+	handler := srv.Handler()
+
+	// Mirror mode: serve the databases to other instances, with its own credentials
+	mirror, err := mmdb.MirrorDirFromEnv(cfg.DataDirectory)
+	if err != nil {
+		return err
+	}
+	if mirror != nil {
+		log.Printf("Mirroring databases from %s at /geoip/databases/{edition}/download", mirror.Dir)
+		defer mirror.Close()
+		if dl != nil {
+			// rebuild the archives of updated databases
+			dl.AddListener(mirror)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/geoip/", mirror.Handler())
+		mux.Handle("/", handler)
+		handler = mux
+	}
+
+	// Metrics server
+	metrics := &http.Server{Addr: cfg.MetricsAddr, Handler: metricsHandler()}
+	go func() {
+		log.Printf("Starting metrics server on %s", cfg.MetricsAddr)
+		if err := metrics.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Metrics server failed: %v", err)
+		}
+	}()
+	defer metrics.Close()
+
+	server := &http.Server{Addr: cfg.Addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("Starting server on %s", cfg.Addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
@@ -53,6 +53,14 @@ func (c *Client) IPInfo(ip net.IP) IPInfo {
 				info.City = name
 			}
 		}
+	} else if countryDB := c.CountryDB(); countryDB != nil {
+		// without a City edition the country comes from the Country edition
+		LookupTotal.WithLabelValues("country").Inc()
+		var rec geoip2.Country
+		if network, ok, err := countryDB.LookupNetwork(ip, &rec); err == nil && ok {
+			info.Network = network.String()
+			info.CountryCode = rec.Country.IsoCode
+		}
 	}
 
 	if asnDB := c.AsnDB(); asnDB != nil {
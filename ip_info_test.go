@@ -0,0 +1,219 @@
+package mmdb
+
+import (
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func mustParseIP(t testing.TB, s string) net.IP {
+	t.Helper()
+	ip := net.ParseIP(s)
+	if ip == nil {
+		t.Fatalf("invalid IP %q", s)
+	}
+	return ip
+}
+
+func TestIPInfo(t *testing.T) {
+	c := newTestClient(t)
+
+	tests := []struct {
+		name     string
+		ip       string
+		expected IPInfo
+	}{
+		{
+			name: "City Only",
+			ip:   "81.2.69.142",
+			expected: IPInfo{
+				IPType:      4,
+				Network:     "81.2.69.0/24",
+				CountryCode: "GB",
+				City:        "London",
+			},
+		},
+		{
+			name: "City And ASN",
+			ip:   "216.160.83.56",
+			expected: IPInfo{
+				IPType:      4,
+				Network:     "216.160.83.0/24",
+				CountryCode: "US",
+				City:        "Milton",
+				ASN:         "Qwest Communications Company, LLC",
+			},
+		},
+		{
+			name: "ASN Only",
+			ip:   "1.128.0.1",
+			expected: IPInfo{
+				IPType:  4,
+				Network: "1.128.0.0/11",
+				ASN:     "Telstra Pty Ltd",
+			},
+		},
+		{
+			name: "IPv6",
+			ip:   "2001:218::1",
+			expected: IPInfo{
+				IPType:      6,
+				Network:     "2001:218::/32",
+				CountryCode: "JP",
+				ASN:         "NTT America, Inc.",
+			},
+		},
+		{
+			name: "Unknown",
+			ip:   "203.0.113.1",
+			expected: IPInfo{
+				IPType: 4,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip := mustParseIP(t, tt.ip)
+			tt.expected.IP = ip
+			tt.expected.CityBuildDate = testBuildEpoch
+			tt.expected.ASNBuildDate = testBuildEpoch
+
+			info := c.IPInfo(ip)
+			if !reflect.DeepEqual(info, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, info)
+			}
+		})
+	}
+}
+
+func TestIPInfoCountryAndASN(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabases(t, dir, testBuildEpoch)
+	t.Setenv(MaxmindBasePath, dir)
+	t.Setenv(MaxmindPinnedGenerations, "")
+
+	c, err := NewClient(WithClientEditions(CountryDatabase, ASNDatabase))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close()
+
+	tests := []struct {
+		name     string
+		ip       string
+		expected IPInfo
+	}{
+		{
+			name: "Country Only",
+			ip:   "81.2.69.142",
+			expected: IPInfo{
+				IPType:      4,
+				Network:     "81.2.69.0/24",
+				CountryCode: "GB",
+			},
+		},
+		{
+			name: "Country And ASN",
+			ip:   "216.160.83.56",
+			expected: IPInfo{
+				IPType:      4,
+				Network:     "216.160.83.0/24",
+				CountryCode: "US",
+				ASN:         "Qwest Communications Company, LLC",
+			},
+		},
+		{
+			name: "ASN Only",
+			ip:   "1.128.0.1",
+			expected: IPInfo{
+				IPType:  4,
+				Network: "1.128.0.0/11",
+				ASN:     "Telstra Pty Ltd",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip := mustParseIP(t, tt.ip)
+			tt.expected.IP = ip
+			tt.expected.ASNBuildDate = testBuildEpoch
+
+			info := c.IPInfo(ip)
+			if !reflect.DeepEqual(info, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, info)
+			}
+		})
+	}
+}
+
+func TestIPInfoNil(t *testing.T) {
+	c := newTestClient(t)
+
+	if info := c.IPInfo(nil); !reflect.DeepEqual(info, IPInfo{}) {
+		t.Errorf("expected empty IPInfo for nil IP, got %+v", info)
+	}
+}
+
+func TestIPInfoFromRequest(t *testing.T) {
+	c := newTestClient(t)
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		forwardedFor  string
+		expectedIP    string
+		expectedCity  string
+		expectedEmpty bool
+	}{
+		{
+			name:         "RemoteAddr With Port",
+			remoteAddr:   "81.2.69.142:51234",
+			expectedIP:   "81.2.69.142",
+			expectedCity: "London",
+		},
+		{
+			name:         "RemoteAddr Without Port",
+			remoteAddr:   "216.160.83.56",
+			expectedIP:   "216.160.83.56",
+			expectedCity: "Milton",
+		},
+		{
+			name:         "X-Forwarded-For Wins",
+			remoteAddr:   "10.0.0.1:8080",
+			forwardedFor: "216.160.83.56, 10.0.0.2",
+			expectedIP:   "216.160.83.56",
+			expectedCity: "Milton",
+		},
+		{
+			name:          "Empty RemoteAddr",
+			expectedEmpty: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+
+			info := c.IPInfoFromRequest(req)
+			if tt.expectedEmpty {
+				if !reflect.DeepEqual(info, IPInfo{}) {
+					t.Errorf("expected empty IPInfo, got %+v", info)
+				}
+				return
+			}
+			if info.IP.String() != tt.expectedIP {
+				t.Errorf("expected IP %s, got %s", tt.expectedIP, info.IP)
+			}
+			if info.City != tt.expectedCity {
+				t.Errorf("expected city %q, got %q", tt.expectedCity, info.City)
+			}
+		})
+	}
+}
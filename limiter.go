@@ -0,0 +1,62 @@
+package mmdb
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultConcurrency is the number of editions downloaded in parallel
+// unless WithConcurrency is given.
+const DefaultConcurrency = 2
+
+// WithConcurrency sets how many editions are downloaded in parallel.
+// Values below 1 are treated as 1.
+func WithConcurrency(n int) Option {
+	return func(d *Downloader) {
+		d.concurrency = n
+	}
+}
+
+// WithRateLimit spaces requests to the download server at least interval
+// apart, across all parallel downloads.
+func WithRateLimit(interval time.Duration) Option {
+	return func(d *Downloader) {
+		d.limiter.interval = interval
+	}
+}
+
+// rateLimiter is shared by all workers of a Downloader. It spaces requests
+// interval apart and lets a Retry-After seen by one worker hold back all of
+// them.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// wait blocks until the caller may send its next request.
+func (l *rateLimiter) wait(ctx context.Context, sleep func(context.Context, time.Duration) error) error {
+	l.mu.Lock()
+	now := time.Now()
+	at := now
+	if l.next.After(at) {
+		at = l.next
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	if delay := at.Sub(now); delay > 0 {
+		return sleep(ctx, delay)
+	}
+	return ctx.Err()
+}
+
+// pause holds back all requests for d.
+func (l *rateLimiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.next) {
+		l.next = until
+	}
+}
@@ -0,0 +1,118 @@
+package mmdb
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := &rateLimiter{interval: time.Second}
+	var waits []time.Duration
+	sleep := func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	for range 3 {
+		if err := l.wait(context.Background(), sleep); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first request goes out right away
+	if len(waits) != 2 {
+		t.Fatalf("expected 2 waits, got %v", waits)
+	}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second} {
+		if waits[i] > want || waits[i] < want-100*time.Millisecond {
+			t.Errorf("wait %d: expected about %s, got %s", i, want, waits[i])
+		}
+	}
+}
+
+func TestRateLimiterPause(t *testing.T) {
+	l := &rateLimiter{}
+	var waits []time.Duration
+	sleep := func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	if err := l.wait(context.Background(), sleep); err != nil {
+		t.Fatal(err)
+	}
+	l.pause(30 * time.Second)
+	l.pause(time.Second) // a shorter pause does not cut the longer one
+	if err := l.wait(context.Background(), sleep); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(waits) != 1 || waits[0] > 30*time.Second || waits[0] < 29*time.Second {
+		t.Errorf("expected one wait of about 30s, got %v", waits)
+	}
+}
+
+func TestDownloadDatabasesConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		wantMax     func(int) bool
+	}{
+		{name: "Sequential", concurrency: 1, wantMax: func(n int) bool { return n == 1 }},
+		{name: "Parallel", concurrency: 3, wantMax: func(n int) bool { return n > 1 && n <= 3 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, srv := newTestDownloader(t, WithConcurrency(tt.concurrency))
+			srv.Delay = 20 * time.Millisecond
+
+			report, err := d.DownloadDatabases(context.Background())
+			if err != nil {
+				t.Fatalf("DownloadDatabases: %v", err)
+			}
+			if n := srv.MaxInFlight(); !tt.wantMax(n) {
+				t.Errorf("unexpected number of parallel requests: %d", n)
+			}
+			for i, edition := range DefaultEditions {
+				if res := report.Results[i]; res.Edition != edition || res.Status != StatusSuccess {
+					t.Errorf("result %d: expected %s success in request order, got %s %s", i, edition, res.Edition, res.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadDatabasesSharedRetryAfter(t *testing.T) {
+	d, srv := newTestDownloader(t, WithConcurrency(3))
+	var (
+		mu    sync.Mutex
+		waits []time.Duration
+	)
+	d.sleep = func(ctx context.Context, wait time.Duration) error {
+		mu.Lock()
+		waits = append(waits, wait)
+		mu.Unlock()
+		return ctx.Err()
+	}
+	srv.RetryAfter = "20"
+	srv.FailNext(429)
+
+	if _, err := d.DownloadDatabases(context.Background()); err != nil {
+		t.Fatalf("DownloadDatabases: %v", err)
+	}
+
+	// the limiter holds back every request after the 429
+	mu.Lock()
+	defer mu.Unlock()
+	long := 0
+	for _, w := range waits {
+		if w > 10*time.Second {
+			long++
+		}
+	}
+	if long < 2 {
+		t.Errorf("expected Retry-After to hold back the other downloads too, got waits %v", waits)
+	}
+}
@@ -0,0 +1,146 @@
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"time"
+)
+
+// MaxmindLock sets the LockMode, see WithLockMode.
+const MaxmindLock = "MAXMIND_LOCK"
+
+// DefaultLockTimeout is how long LockWait waits for another process unless
+// WithLockTimeout is given.
+const DefaultLockTimeout = 10 * time.Minute
+
+// lockPoll is how often a held lock is tried again.
+const lockPoll = 500 * time.Millisecond
+
+// ErrLocked is returned when an edition is locked by another process and
+// the Downloader does not wait for it.
+var ErrLocked = errors.New("mmdb: edition locked by another process")
+
+// LockMode is what a Downloader does when another process, e.g.
+// cmd/downloader run from cron next to cmd/server, is downloading the same
+// edition into the same directory.
+type LockMode string
+
+const (
+	// LockWait waits for the other process, up to the lock timeout.
+	LockWait LockMode = "wait"
+	// LockSkip leaves the edition to the other process; its result has
+	// StatusLocked.
+	LockSkip LockMode = "skip"
+	// LockOff does not lock, for directories only one process writes to.
+	LockOff LockMode = "off"
+)
+
+// WithLockMode sets what to do when an edition is locked by another
+// process, overriding MAXMIND_LOCK. The default is LockWait.
+func WithLockMode(mode LockMode) Option {
+	return func(d *Downloader) {
+		d.lockMode = mode
+	}
+}
+
+// WithLockTimeout sets how long LockWait waits for another process.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(d *Downloader) {
+		d.lockTimeout = timeout
+	}
+}
+
+// lockModeFromEnv reads MAXMIND_LOCK, defaulting to LockWait.
+func lockModeFromEnv() (LockMode, error) {
+	switch mode := LockMode(os.Getenv(MaxmindLock)); mode {
+	case "":
+		return LockWait, nil
+	case LockWait, LockSkip, LockOff:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("mmdb: invalid %s %q, expected wait, skip or off", MaxmindLock, mode)
+	}
+}
+
+// lockPath returns the advisory lock file of edition.
+func lockPath(dir, edition string) string {
+	return path.Join(dir, edition+".lock")
+}
+
+// lockEdition takes the advisory lock guarding the download and swap of
+// edition. It returns ErrLocked if the lock is held and the mode is
+// LockSkip. The returned function releases the lock.
+func (d *Downloader) lockEdition(ctx context.Context, edition string) (func(), error) {
+	return d.lock(ctx, edition, lockPath(d.BasePath, edition))
+}
+
+// lock takes the advisory lock of file on behalf of name, an edition or
+// "all" for the lock file of a whole run.
+func (d *Downloader) lock(ctx context.Context, name, file string) (func(), error) {
+	if d.lockMode == LockOff {
+		return func() {}, nil
+	}
+
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("lock: %w", err)
+	}
+	locked, err := tryLock(f)
+	if err == nil && !locked {
+		err = d.waitLock(ctx, name, f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		if err := unlock(f); err != nil {
+			log.Printf("mmdb [%s] unlock: %v", name, err)
+		}
+		f.Close()
+	}, nil
+}
+
+// WithLockFile additionally holds the lock file at path for each whole
+// DownloadDatabases run, like geoipupdate's LockFile. If it is held and
+// the mode is LockSkip, all editions are skipped with StatusLocked.
+// NewDownloader fails if it is combined with LockOff.
+func WithLockFile(path string) Option {
+	return func(d *Downloader) {
+		d.lockFile = path
+	}
+}
+
+// waitLock handles a lock held by another process according to the lock
+// mode, returning once f is locked.
+func (d *Downloader) waitLock(ctx context.Context, name string, f *os.File) error {
+	if d.lockMode == LockSkip {
+		DownloadLockContentionTotal.WithLabelValues(name, "skipped").Inc()
+		return ErrLocked
+	}
+
+	log.Printf("mmdb [%s] locked by another process, waiting", name)
+	if d.lockTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.lockTimeout)
+		defer cancel()
+	}
+	for {
+		if err := d.sleep(ctx, lockPoll); err != nil {
+			DownloadLockContentionTotal.WithLabelValues(name, "timeout").Inc()
+			return fmt.Errorf("lock: %w", err)
+		}
+		locked, err := tryLock(f)
+		if err != nil {
+			return fmt.Errorf("lock: %w", err)
+		}
+		if locked {
+			DownloadLockContentionTotal.WithLabelValues(name, "waited").Inc()
+			return nil
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package mmdb
+
+import "os"
+
+// tryLock does not lock on this platform; only one process may download
+// into a directory.
+func tryLock(f *os.File) (bool, error) {
+	return true, nil
+}
+
+func unlock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,196 @@
+//go:build unix
+
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// holdLock takes the lock of edition the way another process would and
+// returns a function releasing it.
+func holdLock(t testing.TB, dir, edition string) func() {
+	t.Helper()
+	f, err := os.OpenFile(lockPath(dir, edition), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked, err := tryLock(f); err != nil || !locked {
+		t.Fatalf("expected to take the lock, got %v, %v", locked, err)
+	}
+	released := false
+	release := func() {
+		if !released {
+			released = true
+			unlock(f)
+			f.Close()
+		}
+	}
+	t.Cleanup(release)
+	return release
+}
+
+func contentionCount(db, outcome string) float64 {
+	return testutil.ToFloat64(DownloadLockContentionTotal.WithLabelValues(db, outcome))
+}
+
+func TestLockSkip(t *testing.T) {
+	d, _ := newTestDownloader(t, WithLockMode(LockSkip))
+	holdLock(t, d.BasePath, CityDatabase)
+	before := contentionCount(CityDatabase, "skipped")
+	DownloadLastSuccessTimestamp.WithLabelValues(CityDatabase).Set(0)
+
+	res := d.downloadOne(context.Background(), CityDatabase)
+	if res.Err != nil || res.Status != StatusLocked {
+		t.Fatalf("expected locked edition to be skipped, got %s: %v", res.Status, res.Err)
+	}
+	if _, err := os.Stat(dbPath(d.BasePath, CityDatabase)); !os.IsNotExist(err) {
+		t.Errorf("expected no database written, got %v", err)
+	}
+	if got := contentionCount(CityDatabase, "skipped") - before; got != 1 {
+		t.Errorf("expected skipped contention counter to increase by 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(DownloadLastSuccessTimestamp.WithLabelValues(CityDatabase)); got != 0 {
+		t.Errorf("expected no success timestamp for a locked edition, got %v", got)
+	}
+
+	// other editions are not affected
+	if res := d.downloadOne(context.Background(), ASNDatabase); res.Status != StatusSuccess {
+		t.Errorf("expected unlocked edition to be downloaded, got %s: %v", res.Status, res.Err)
+	}
+}
+
+func TestLockWait(t *testing.T) {
+	d, _ := newTestDownloader(t)
+	release := holdLock(t, d.BasePath, CityDatabase)
+	before := contentionCount(CityDatabase, "waited")
+
+	// the other process finishes while we wait
+	polls := 0
+	d.sleep = func(ctx context.Context, _ time.Duration) error {
+		if polls++; polls == 2 {
+			release()
+		}
+		return ctx.Err()
+	}
+
+	if res := d.downloadOne(context.Background(), CityDatabase); res.Err != nil || res.Status != StatusSuccess {
+		t.Fatalf("expected download after waiting, got %s: %v", res.Status, res.Err)
+	}
+	if polls != 2 {
+		t.Errorf("expected 2 polls, got %d", polls)
+	}
+	if got := contentionCount(CityDatabase, "waited") - before; got != 1 {
+		t.Errorf("expected waited contention counter to increase by 1, got %v", got)
+	}
+
+	// the lock is released after the download
+	holdLock(t, d.BasePath, CityDatabase)
+}
+
+func TestLockTimeout(t *testing.T) {
+	d, _ := newTestDownloader(t, WithLockTimeout(10*time.Millisecond))
+	holdLock(t, d.BasePath, CityDatabase)
+	d.sleep = sleepContext
+	before := contentionCount(CityDatabase, "timeout")
+
+	res := d.downloadOne(context.Background(), CityDatabase)
+	if !errors.Is(res.Err, context.DeadlineExceeded) || res.Status != StatusFailure {
+		t.Fatalf("expected failure after lock timeout, got %s: %v", res.Status, res.Err)
+	}
+	if got := contentionCount(CityDatabase, "timeout") - before; got != 1 {
+		t.Errorf("expected timeout contention counter to increase by 1, got %v", got)
+	}
+}
+
+func TestLockRollback(t *testing.T) {
+	d, _ := newTestDownloader(t, WithLockMode(LockSkip))
+	holdLock(t, d.BasePath, CityDatabase)
+	if _, err := d.Rollback(CityDatabase, 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+}
+
+func TestLockOff(t *testing.T) {
+	d, _ := newTestDownloader(t, WithLockMode(LockOff))
+	holdLock(t, d.BasePath, CityDatabase)
+	if res := d.downloadOne(context.Background(), CityDatabase); res.Status != StatusSuccess {
+		t.Errorf("expected download without locking, got %s: %v", res.Status, res.Err)
+	}
+}
+
+func TestLockFileLockOff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".geoipupdate.lock")
+	t.Setenv(MaxmindAccountId, testAccountID)
+	t.Setenv(MaxmindLicenseKey, testLicenseKey)
+	t.Setenv(MaxmindLock, string(LockOff))
+	if _, err := NewDownloader(WithLockFile(path)); err == nil || !strings.Contains(err.Error(), "lock file") {
+		t.Errorf("expected an error for a lock file with locking off, got %v", err)
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".geoipupdate.lock")
+	d, srv := newTestDownloader(t, WithLockMode(LockSkip), WithLockFile(path))
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if locked, err := tryLock(f); err != nil || !locked {
+		t.Fatalf("expected to take the lock, got %v, %v", locked, err)
+	}
+
+	report, err := d.DownloadDatabases(context.Background(), CityDatabase, ASNDatabase)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, res := range report.Results {
+		if res.Status != StatusLocked {
+			t.Errorf("%s: expected %s, got %s", res.Edition, StatusLocked, res.Status)
+		}
+	}
+	if n := len(srv.Requests()); n != 0 {
+		t.Errorf("expected no requests while the lock file is held, got %d", n)
+	}
+
+	unlock(f)
+	report, err = d.DownloadDatabases(context.Background(), CityDatabase)
+	if err != nil || report.Results[0].Status != StatusSuccess {
+		t.Errorf("expected download after the lock is released, got %v", err)
+	}
+}
+
+func TestLockModeFromEnv(t *testing.T) {
+	tests := []struct {
+		env      string
+		expected LockMode
+		wantErr  bool
+	}{
+		{env: "", expected: LockWait},
+		{env: "skip", expected: LockSkip},
+		{env: "off", expected: LockOff},
+		{env: "never", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Setenv(MaxmindLock, tt.env)
+			got, err := lockModeFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
@@ -36,6 +36,69 @@ var (
 			Name: "mmdb_download_total",
 			Help: "Total number of database downloads.",
 		},
-		[]string{"database", "status"}, // status: "success", "failure", "skipped"
+		[]string{"database", "status"}, // status: "success", "failure", "checksum_mismatch", "skipped", "locked"
+	)
+
+	DownloadBytesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mmdb_download_bytes_total",
+			Help: "Total number of archive bytes received.",
+		},
+		[]string{"database"},
+	)
+
+	DownloadResponsesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mmdb_download_http_responses_total",
+			Help: "Total number of responses from the download server.",
+		},
+		[]string{"method", "code"}, // code: HTTP status or "error"
+	)
+
+	DownloadRetriesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mmdb_download_retries_total",
+			Help: "Total number of retried download requests.",
+		},
+		[]string{"code"}, // code of the failed attempt: HTTP status or "error"
+	)
+
+	DownloadLockContentionTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mmdb_download_lock_contention_total",
+			Help: "Total number of downloads that found their edition locked by another process.",
+		},
+		[]string{"database", "outcome"}, // outcome: "waited", "skipped", "timeout"
+	)
+
+	DownloadLastSuccessTimestamp = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mmdb_download_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful download or up-to-date check.",
+		},
+		[]string{"database"},
+	)
+
+	DownloadNextRunTimestamp = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mmdb_download_next_run_timestamp_seconds",
+			Help: "Unix time of the next scheduled download.",
+		},
+	)
+
+	DatabaseUpdatesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mmdb_database_updates_total",
+			Help: "Total number of database files replaced by the downloader, by download or rollback.",
+		},
+		[]string{"database"},
+	)
+
+	MirrorRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mmdb_mirror_requests_total",
+			Help: "Total number of requests to the mirror endpoint.",
+		},
+		[]string{"edition", "code"},
 	)
 )
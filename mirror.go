@@ -0,0 +1,357 @@
+package mmdb
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	MirrorAccountId  = "MIRROR_ACCOUNT_ID"
+	MirrorLicenseKey = "MIRROR_LICENSE_KEY"
+)
+
+// Mirror serves the databases in a directory through a MaxMind-compatible
+// /geoip/databases/{edition}/download endpoint, so that other instances
+// can download from it with WithMirror instead of from MaxMind.
+//
+// Each database is packed into a tar.gz archive the way MaxMind does,
+// together with its installed license and copyright notices, with its
+// modification time as Last-Modified. Archives are built on demand, one
+// edition at a time, and cached in CacheDir until the database changes or
+// DatabaseUpdated is called for it; a Mirror is a Listener.
+type Mirror struct {
+	Dir        string
+	AccountID  string
+	LicenseKey string
+	// CacheDir holds the built archives, os.TempDir() if empty.
+	CacheDir string
+
+	mu       sync.Mutex
+	editions map[string]*mirrorEdition
+}
+
+// mirrorEdition is the cached archive of an edition. Its lock is held
+// while building, so that concurrent requests build it once.
+type mirrorEdition struct {
+	mu      sync.Mutex
+	archive *mirrorArchive
+	// gen is incremented by DatabaseUpdated to invalidate the archive.
+	gen atomic.Uint64
+}
+
+type mirrorArchive struct {
+	gen      uint64
+	modTime  time.Time
+	size     int64
+	path     string
+	checksum string
+	name     string
+}
+
+// NewMirror returns a Mirror of dir accepting the given credentials.
+func NewMirror(dir, accountID, licenseKey string) *Mirror {
+	return &Mirror{
+		Dir:        dir,
+		AccountID:  accountID,
+		LicenseKey: licenseKey,
+		editions:   make(map[string]*mirrorEdition),
+	}
+}
+
+// MirrorFromEnv returns a Mirror of MAXMIND_BASE_PATH accepting
+// MIRROR_ACCOUNT_ID and MIRROR_LICENSE_KEY, or nil if neither is set.
+func MirrorFromEnv() (*Mirror, error) {
+	dir := os.Getenv(MaxmindBasePath)
+	if dir == "" {
+		dir = "."
+	}
+	return MirrorDirFromEnv(dir)
+}
+
+// MirrorDirFromEnv is like MirrorFromEnv for a Mirror of dir, e.g. the
+// directory a Downloader configured by other means writes to.
+func MirrorDirFromEnv(dir string) (*Mirror, error) {
+	account := os.Getenv(MirrorAccountId)
+	license := os.Getenv(MirrorLicenseKey)
+	if account == "" && license == "" {
+		return nil, nil
+	}
+	if account == "" || license == "" {
+		return nil, fmt.Errorf("mmdb: mirror needs both %s and %s", MirrorAccountId, MirrorLicenseKey)
+	}
+	return NewMirror(dir, account, license), nil
+}
+
+// Handler returns the handler of the download endpoint.
+func (m *Mirror) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /geoip/databases/{edition}/download", m.handleDownload)
+	return mux
+}
+
+func (m *Mirror) handleDownload(w http.ResponseWriter, r *http.Request) {
+	edition := r.PathValue("edition")
+	_, known := knownEditions[edition]
+	if !known && filepath.IsLocal(edition) && !strings.ContainsAny(edition, `/\`) {
+		// editions of other publishers are served if their database is here
+		_, err := os.Stat(dbPath(m.Dir, edition))
+		known = err == nil
+	}
+	sw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		label := edition
+		if !known {
+			label = "unknown"
+		}
+		MirrorRequestsTotal.WithLabelValues(label, strconv.Itoa(sw.status)).Inc()
+	}()
+
+	account, license, ok := r.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(account), []byte(m.AccountID)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(license), []byte(m.LicenseKey)) != 1 {
+		sw.Header().Set("WWW-Authenticate", `Basic realm="mmdb mirror"`)
+		http.Error(sw, "Invalid account ID or license key", http.StatusUnauthorized)
+		return
+	}
+
+	if !known {
+		http.Error(sw, "Database edition not found", http.StatusNotFound)
+		return
+	}
+	a, f, err := m.archive(edition)
+	if os.IsNotExist(err) {
+		http.Error(sw, "Database edition not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("mmdb [%s] pack for mirror: %v", edition, err)
+		http.Error(sw, "Failed to pack database", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	switch suffix := r.URL.Query().Get("suffix"); suffix {
+	case "tar.gz":
+		sw.Header().Set("Content-Type", "application/gzip")
+		sw.Header().Set("Content-Disposition", "attachment; filename="+a.name)
+		sw.Header().Set("ETag", `"`+a.checksum[:16]+`"`)
+		http.ServeContent(sw, r, a.name, a.modTime, f)
+	case "tar.gz.sha256":
+		sw.Header().Set("Content-Type", "text/plain")
+		sw.Header().Set("Content-Disposition", "attachment; filename="+a.name+".sha256")
+		sw.Header().Set("Last-Modified", a.modTime.UTC().Format(http.TimeFormat))
+		fmt.Fprintf(sw, "%s  %s\n", a.checksum, a.name)
+	default:
+		http.Error(sw, fmt.Sprintf("Unsupported suffix %q", suffix), http.StatusBadRequest)
+	}
+}
+
+// DatabaseUpdated drops the cached archive of edition, so that it is
+// rebuilt with the notices installed along with the new database even if
+// the file looks unchanged.
+func (m *Mirror) DatabaseUpdated(edition string) {
+	m.edition(edition).gen.Add(1)
+}
+
+// Close removes the cached archives.
+func (m *Mirror) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var errs []error
+	for _, e := range m.editions {
+		e.mu.Lock()
+		if e.archive != nil {
+			if err := os.Remove(e.archive.path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			e.archive = nil
+		}
+		e.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Mirror) edition(name string) *mirrorEdition {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.editions[name]
+	if !ok {
+		e = &mirrorEdition{}
+		m.editions[name] = e
+	}
+	return e
+}
+
+// archive returns the packed database of edition, opened for reading,
+// rebuilding it if the file changed.
+func (m *Mirror) archive(edition string) (*mirrorArchive, *os.File, error) {
+	// stat the open file, so that data and modification time match even
+	// if the database is replaced meanwhile
+	db, err := os.Open(dbPath(m.Dir, edition))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+	info, err := db.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	modTime, size := info.ModTime(), info.Size()
+
+	e := m.edition(edition)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	gen := e.gen.Load()
+	if a := e.archive; a != nil && a.gen == gen && a.modTime.Equal(modTime) && a.size == size {
+		f, err := os.Open(a.path)
+		if err == nil {
+			return a, f, nil
+		}
+		// removed from the cache directory, build it again
+	}
+
+	notices, err := readNotices(m.Dir, edition)
+	if err != nil {
+		return nil, nil, err
+	}
+	dir := m.CacheDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	f, err := os.CreateTemp(dir, "mmdb-mirror-"+edition+"-*.tar.gz")
+	if err != nil {
+		return nil, nil, err
+	}
+	h := sha256.New()
+	err = packArchive(io.MultiWriter(f, h), edition, modTime, db, size, notices)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, nil, err
+	}
+
+	if e.archive != nil {
+		// open handles of requests being served keep the data
+		os.Remove(e.archive.path)
+	}
+	e.archive = &mirrorArchive{
+		gen:      gen,
+		modTime:  modTime,
+		size:     size,
+		path:     f.Name(),
+		checksum: hex.EncodeToString(h.Sum(nil)),
+		name:     edition + "_" + modTime.UTC().Format("20060102") + ".tar.gz",
+	}
+	return e.archive, f, nil
+}
+
+// notice is a license or copyright notice installed next to a database.
+type notice struct {
+	name string
+	data []byte
+}
+
+// readNotices reads the notices installed next to the database of edition
+// in dir, sorted by name.
+func readNotices(dir, edition string) ([]notice, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, edition+".*"))
+	if err != nil {
+		return nil, err
+	}
+	var notices []notice
+	for _, p := range paths {
+		name := strings.TrimPrefix(filepath.Base(p), edition+".")
+		if !isNotice(name) || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		data, err := os.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		notices = append(notices, notice{name: name, data: data})
+	}
+	return notices, nil
+}
+
+// packArchive writes a database of size bytes read from db to w as a
+// tar.gz archive laid out like MaxMind's, with the notices next to it
+// under a directory named after the edition and date. The archive only
+// depends on its inputs, so its checksum is stable.
+func packArchive(w io.Writer, edition string, modTime time.Time, db io.Reader, size int64, notices []notice) error {
+	dir := edition + "_" + modTime.UTC().Format("20060102")
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     dir + "/",
+		Mode:     0o755,
+		ModTime:  modTime,
+	}); err != nil {
+		return err
+	}
+	for _, n := range notices {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(dir, n.name),
+			Mode:     0o644,
+			Size:     int64(len(n.data)),
+			ModTime:  modTime,
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(n.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Join(dir, edition+dbSuffix),
+		Mode:     0o644,
+		Size:     size,
+		ModTime:  modTime,
+	}); err != nil {
+		return err
+	}
+	// databases are replaced by renaming, so the open file keeps its size
+	if _, err := io.CopyN(tw, db, size); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// statusRecorder records the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,275 @@
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/NoUmlautsAllowed/go-mmdb/mmdbtest"
+)
+
+const (
+	testMirrorAccount = "replica"
+	testMirrorLicense = "replica-secret"
+)
+
+// newTestMirror serves the test databases of a temporary directory through
+// a Mirror and returns the directory and the download URL.
+func newTestMirror(t testing.TB) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestDatabases(t, dir, testBuildEpoch)
+	for _, edition := range []string{CityDatabase, CountryDatabase, ASNDatabase} {
+		if err := os.Chtimes(dbPath(dir, edition), testModTime, testModTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewMirror(dir, testMirrorAccount, testMirrorLicense)
+	m.CacheDir = t.TempDir()
+	srv := httptest.NewServer(m.Handler())
+	t.Cleanup(srv.Close)
+	return dir, srv.URL + "/geoip/databases/%s/download?suffix=tar.gz"
+}
+
+func TestMirror(t *testing.T) {
+	dir, url := newTestMirror(t)
+	d := newSourceDownloader(t, WithMirror(url, testMirrorAccount, testMirrorLicense))
+	ctx := context.Background()
+
+	report, err := d.DownloadDatabases(ctx)
+	if err != nil {
+		t.Fatalf("download from mirror: %v", err)
+	}
+	for _, res := range report.Results {
+		if res.Status != StatusSuccess || !res.RemoteTime.Equal(testModTime) {
+			t.Errorf("expected %s to be downloaded with time %s, got %s at %s", res.Edition, testModTime, res.Status, res.RemoteTime)
+		}
+		if got, err := buildEpoch(dbPath(d.BasePath, res.Edition)); err != nil || got != testBuildEpoch {
+			t.Errorf("expected %s build %d, got %d, %v", res.Edition, testBuildEpoch, got, err)
+		}
+	}
+
+	// unchanged databases are answered with 304
+	if res := d.downloadOne(ctx, CityDatabase); res.Err != nil || res.Status != StatusSkipped {
+		t.Fatalf("expected unchanged database to be skipped, got %s: %v", res.Status, res.Err)
+	}
+
+	// a new release on the mirror is picked up
+	if err := mmdbtest.WriteDatabase(dbPath(dir, CityDatabase), CityDatabase, testBuildEpoch+3600); err != nil {
+		t.Fatal(err)
+	}
+	newModTime := testModTime.Add(time.Hour)
+	if err := os.Chtimes(dbPath(dir, CityDatabase), newModTime, newModTime); err != nil {
+		t.Fatal(err)
+	}
+	if res := d.downloadOne(ctx, CityDatabase); res.Err != nil || res.Status != StatusSuccess {
+		t.Fatalf("expected new release to be downloaded, got %s: %v", res.Status, res.Err)
+	}
+	if got, err := buildEpoch(dbPath(d.BasePath, CityDatabase)); err != nil || got != testBuildEpoch+3600 {
+		t.Errorf("expected build %d, got %d, %v", testBuildEpoch+3600, got, err)
+	}
+}
+
+func TestMirrorNotices(t *testing.T) {
+	dir, url := newTestMirror(t)
+	notices := map[string]string{
+		"LICENSE.txt":   "license of the test databases\n",
+		"COPYRIGHT.txt": "copyright of the test databases\n",
+	}
+	for name, data := range notices {
+		if err := os.WriteFile(noticePath(dir, CityDatabase, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := newSourceDownloader(t, WithMirror(url, testMirrorAccount, testMirrorLicense))
+	if res := d.downloadOne(context.Background(), CityDatabase); res.Err != nil || res.Status != StatusSuccess {
+		t.Fatalf("expected download from mirror, got %s: %v", res.Status, res.Err)
+	}
+	for name, want := range notices {
+		got, err := os.ReadFile(noticePath(d.BasePath, CityDatabase, name))
+		if err != nil || string(got) != want {
+			t.Errorf("expected %s %q, got %q, %v", name, want, got, err)
+		}
+	}
+}
+
+func TestMirrorCache(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabases(t, dir, testBuildEpoch)
+	m := NewMirror(dir, testMirrorAccount, testMirrorLicense)
+	m.CacheDir = t.TempDir()
+
+	archive := func(edition string) *mirrorArchive {
+		t.Helper()
+		a, f, err := m.archive(edition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		return a
+	}
+	cached := func() int {
+		t.Helper()
+		entries, err := os.ReadDir(m.CacheDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+
+	// concurrent requests build each edition once
+	editions := []string{CityDatabase, CountryDatabase, ASNDatabase}
+	var wg sync.WaitGroup
+	sums := make([][8]string, len(editions))
+	for i, edition := range editions {
+		for j := range sums[i] {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				a, f, err := m.archive(edition)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				f.Close()
+				sums[i][j] = a.checksum
+			}()
+		}
+	}
+	wg.Wait()
+	for i, edition := range editions {
+		for _, sum := range sums[i] {
+			if sum != sums[i][0] {
+				t.Errorf("expected one %s archive, got checksums %q", edition, sums[i])
+				break
+			}
+		}
+	}
+	if n := cached(); n != len(editions) {
+		t.Errorf("expected %d cached archives, got %d", len(editions), n)
+	}
+
+	// a notice installed with the same database is only packed once the
+	// mirror is told about the update
+	before := archive(CityDatabase)
+	if err := os.WriteFile(noticePath(dir, CityDatabase, "LICENSE.txt"), []byte("license\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if a := archive(CityDatabase); a.checksum != before.checksum {
+		t.Errorf("expected the cached archive %s, got %s", before.checksum, a.checksum)
+	}
+	m.DatabaseUpdated(CityDatabase)
+	if a := archive(CityDatabase); a.checksum == before.checksum {
+		t.Errorf("expected a new archive after DatabaseUpdated, got %s", a.checksum)
+	}
+	if _, err := os.Stat(before.path); !os.IsNotExist(err) {
+		t.Errorf("expected the old archive to be removed, got %v", err)
+	}
+	if n := cached(); n != len(editions) {
+		t.Errorf("expected %d cached archives, got %d", len(editions), n)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := cached(); n != 0 {
+		t.Errorf("expected no cached archives after Close, got %d", n)
+	}
+}
+
+func TestMirrorThirdPartyEdition(t *testing.T) {
+	const edition = "dbip-city-lite"
+	dir, url := newTestMirror(t)
+	if err := mmdbtest.WriteDatabase(dbPath(dir, edition), CityDatabase, testBuildEpoch); err != nil {
+		t.Fatal(err)
+	}
+
+	// the edition is not MaxMind's, but its database is on the mirror
+	d := newSourceDownloader(t, WithMirror(url, testMirrorAccount, testMirrorLicense), WithEditions(edition))
+	if err := d.ValidateEditions(d.Editions); err != nil {
+		t.Fatalf("expected any edition of a mirror to be valid, got %v", err)
+	}
+	report, err := d.DownloadDatabases(context.Background())
+	if err != nil {
+		t.Fatalf("download from mirror: %v", err)
+	}
+	if res := report.Results[0]; res.Status != StatusSuccess {
+		t.Fatalf("expected %s to be downloaded, got %s: %v", edition, res.Status, res.Err)
+	}
+	if got, err := buildEpoch(dbPath(d.BasePath, edition)); err != nil || got != testBuildEpoch {
+		t.Errorf("expected %s build %d, got %d, %v", edition, testBuildEpoch, got, err)
+	}
+	if got := testutil.ToFloat64(MirrorRequestsTotal.WithLabelValues(edition, "200")); got == 0 {
+		t.Errorf("expected request of %s to be counted under its edition", edition)
+	}
+}
+
+func TestMirrorErrors(t *testing.T) {
+	dir, url := newTestMirror(t)
+	if err := os.Remove(dbPath(dir, ASNDatabase)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		edition  string
+		license  string
+		expected error
+		status   int
+	}{
+		{name: "Wrong License", edition: CityDatabase, license: "wrong", expected: ErrUnauthorized},
+		{name: "Missing Database", edition: ASNDatabase, license: testMirrorLicense, status: http.StatusNotFound},
+		{name: "Unknown Edition", edition: "GeoLite2-Nope", license: testMirrorLicense, status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newSourceDownloader(t, WithMirror(url, testMirrorAccount, tt.license), WithRetryPolicy(testRetryPolicy))
+			err := d.downloadOne(context.Background(), tt.edition).Err
+			if tt.expected != nil && !errors.Is(err, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, err)
+			}
+			var se *StatusError
+			if tt.status != 0 && (!errors.As(err, &se) || se.StatusCode != tt.status) {
+				t.Errorf("expected status %d, got %v", tt.status, err)
+			}
+		})
+	}
+}
+
+func TestMirrorFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		account string
+		license string
+		enabled bool
+		wantErr bool
+	}{
+		{name: "Disabled"},
+		{name: "Enabled", account: "a", license: "l", enabled: true},
+		{name: "Missing License", account: "a", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(MirrorAccountId, tt.account)
+			t.Setenv(MirrorLicenseKey, tt.license)
+			m, err := MirrorFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if (m != nil) != tt.enabled {
+				t.Errorf("expected mirror enabled %v, got %v", tt.enabled, m)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package mmdbtest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry is a stand-in for an OCI distribution registry, serving the
+// manifests and blobs of artifacts pushed with PushArtifact like
+// "oras push" would. If Username is set, pulls need a bearer token from
+// its /token endpoint, which asks for Username and Password.
+type Registry struct {
+	*httptest.Server
+
+	Username string
+	Password string
+
+	mu         sync.Mutex
+	manifests  map[string][]byte
+	blobs      map[string][]byte
+	interrupts []int64
+	requests   []Request
+}
+
+// registryToken is the bearer token issued by a Registry.
+const registryToken = "mmdbtest-registry-token"
+
+// NewRegistry starts a Registry accepting the given credentials, or
+// anonymous pulls if username is empty. The caller must call Close when
+// finished.
+func NewRegistry(username, password string) *Registry {
+	r := &Registry{
+		Username:  username,
+		Password:  password,
+		manifests: make(map[string][]byte),
+		blobs:     make(map[string][]byte),
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+// PushArtifact stores data as the only layer of an artifact manifest
+// tagged repository:tag, with title as its file name and created as
+// creation time, and returns the digest of the manifest.
+func (r *Registry) PushArtifact(repository, tag, title string, data []byte, created time.Time) string {
+	layer := digest(data)
+	config := []byte("{}")
+	manifest, _ := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"artifactType":  "application/vnd.maxmind.mmdb",
+		"config": map[string]any{
+			"mediaType": "application/vnd.oci.empty.v1+json",
+			"digest":    digest(config),
+			"size":      len(config),
+		},
+		"layers": []map[string]any{{
+			"mediaType":   "application/vnd.oci.image.layer.v1.tar",
+			"digest":      layer,
+			"size":        len(data),
+			"annotations": map[string]string{"org.opencontainers.image.title": title},
+		}},
+		"annotations": map[string]string{
+			"org.opencontainers.image.created": created.UTC().Format(time.RFC3339),
+		},
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blobs[layer] = data
+	r.blobs[digest(config)] = config
+	r.manifests[repository+":"+tag] = manifest
+	return digest(manifest)
+}
+
+// InterruptNext makes the next blob downloads break off after the given
+// number of body bytes, in order, by aborting the connection.
+func (r *Registry) InterruptNext(after ...int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interrupts = append(r.interrupts, after...)
+}
+
+// Requests returns the requests received so far. Edition holds the path.
+func (r *Registry) Requests() []Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Request(nil), r.requests...)
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (r *Registry) serve(w http.ResponseWriter, req *http.Request) {
+	sw := &statusWriter{ResponseWriter: w, limit: -1}
+	defer func() {
+		r.mu.Lock()
+		r.requests = append(r.requests, Request{
+			Method:  req.Method,
+			Edition: req.URL.Path,
+			Header:  req.Header.Clone(),
+			Status:  sw.status,
+		})
+		r.mu.Unlock()
+	}()
+
+	if req.URL.Path == "/token" {
+		r.serveToken(sw, req)
+		return
+	}
+	name, kind, ref, ok := parseRegistryPath(req.URL.Path)
+	if !ok {
+		registryError(sw, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+	if r.Username != "" && req.Header.Get("Authorization") != "Bearer "+registryToken {
+		sw.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="mmdbtest",scope="repository:%s:pull"`, r.URL, name))
+		registryError(sw, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		registryError(sw, http.StatusMethodNotAllowed, "UNSUPPORTED", req.Method)
+		return
+	}
+
+	r.mu.Lock()
+	var data []byte
+	if kind == "manifests" {
+		data = r.manifests[name+":"+ref]
+		for _, m := range r.manifests {
+			if digest(m) == ref {
+				data = m
+			}
+		}
+	} else {
+		data = r.blobs[ref]
+		if req.Method == http.MethodGet && len(r.interrupts) > 0 {
+			sw.limit = r.interrupts[0]
+			r.interrupts = r.interrupts[1:]
+		}
+	}
+	r.mu.Unlock()
+	if data == nil {
+		registryError(sw, http.StatusNotFound, strings.ToUpper(strings.TrimSuffix(kind, "s"))+"_UNKNOWN", ref+" not found")
+		return
+	}
+
+	sw.Header().Set("Docker-Content-Digest", digest(data))
+	if kind == "manifests" {
+		sw.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+	} else {
+		sw.Header().Set("Content-Type", "application/octet-stream")
+	}
+	http.ServeContent(sw, req, "", time.Time{}, bytes.NewReader(data))
+}
+
+// serveToken issues the bearer token for the Registry's credentials.
+func (r *Registry) serveToken(w http.ResponseWriter, req *http.Request) {
+	user, password, _ := req.BasicAuth()
+	if user != r.Username || password != r.Password {
+		registryError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials")
+		return
+	}
+	body := `{"token":"` + registryToken + `"}`
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write([]byte(body))
+}
+
+// parseRegistryPath splits /v2/{name}/manifests/{ref} and
+// /v2/{name}/blobs/{digest}.
+func parseRegistryPath(p string) (name, kind, ref string, ok bool) {
+	rest, ok := strings.CutPrefix(p, "/v2/")
+	if !ok {
+		return "", "", "", false
+	}
+	for _, kind := range []string{"manifests", "blobs"} {
+		if i := strings.LastIndex(rest, "/"+kind+"/"); i > 0 {
+			return rest[:i], kind, rest[i+len(kind)+2:], true
+		}
+	}
+	return "", "", "", false
+}
+
+func registryError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"errors":[{"code":%q,"message":%q}]}`, code, message)
+}
@@ -0,0 +1,137 @@
+package mmdbtest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NoUmlautsAllowed/go-mmdb/internal/sigv4"
+)
+
+// S3Server is a MinIO-style stand-in for an S3-compatible object store. It
+// serves objects from path-style URLs (/{bucket}/{key}), checks AWS
+// Signature Version 4, and honors conditional and Range requests.
+type S3Server struct {
+	*httptest.Server
+
+	AccessKeyID     string
+	SecretAccessKey string
+	Region          string
+
+	mu         sync.Mutex
+	objects    map[string]s3Object
+	interrupts []int64
+	requests   []Request
+}
+
+type s3Object struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+// NewS3Server starts an S3Server accepting the given key pair for region.
+// The caller must call Close when finished.
+func NewS3Server(accessKeyID, secretAccessKey, region string) *S3Server {
+	s := &S3Server{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		Region:          region,
+		objects:         make(map[string]s3Object),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// PutObject stores data under key in bucket.
+func (s *S3Server) PutObject(bucket, key string, data []byte, modTime time.Time) {
+	sum := md5.Sum(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[bucket+"/"+key] = s3Object{
+		data:    data,
+		etag:    `"` + hex.EncodeToString(sum[:]) + `"`,
+		modTime: modTime.UTC().Truncate(time.Second),
+	}
+}
+
+// DeleteObject removes key from bucket.
+func (s *S3Server) DeleteObject(bucket, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, bucket+"/"+key)
+}
+
+// InterruptNext makes the next object downloads break off after the given
+// number of body bytes, in order, by aborting the connection.
+func (s *S3Server) InterruptNext(after ...int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interrupts = append(s.interrupts, after...)
+}
+
+// Requests returns the requests received so far. Edition holds the object
+// path.
+func (s *S3Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *S3Server) serve(w http.ResponseWriter, r *http.Request) {
+	sw := &statusWriter{ResponseWriter: w, limit: -1}
+	defer func() {
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{
+			Method:  r.Method,
+			Edition: strings.TrimPrefix(r.URL.Path, "/"),
+			Header:  r.Header.Clone(),
+			Status:  sw.status,
+		})
+		s.mu.Unlock()
+	}()
+
+	creds := sigv4.Credentials{AccessKeyID: s.AccessKeyID, SecretAccessKey: s.SecretAccessKey}
+	if err := sigv4.Verify(r, creds, s.Region, "s3"); err != nil {
+		s3Error(sw, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s3Error(sw, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+		return
+	}
+
+	s.mu.Lock()
+	obj, ok := s.objects[strings.TrimPrefix(r.URL.Path, "/")]
+	s.mu.Unlock()
+	if !ok {
+		s3Error(sw, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		s.mu.Lock()
+		if len(s.interrupts) > 0 {
+			sw.limit = s.interrupts[0]
+			s.interrupts = s.interrupts[1:]
+		}
+		s.mu.Unlock()
+	}
+
+	sw.Header().Set("ETag", obj.etag)
+	sw.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(sw, r, "", obj.modTime, bytes.NewReader(obj.data))
+}
+
+func s3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
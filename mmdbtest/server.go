@@ -0,0 +1,331 @@
+package mmdbtest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Server is a fake of MaxMind's database download API at
+// /geoip/databases/{edition}/download. It checks basic auth, answers HEAD
+// with Last-Modified and ETag, serves tar.gz archives (suffix=tar.gz) and
+// their checksums (suffix=tar.gz.sha256), and honors conditional and Range
+// requests (If-None-Match, If-Modified-Since, Range, If-Range).
+type Server struct {
+	*httptest.Server
+
+	AccountID  string
+	LicenseKey string
+
+	// RetryAfter is sent with every 429 response if set.
+	RetryAfter string
+
+	// Delay is waited before answering each request.
+	Delay time.Duration
+
+	mu         sync.Mutex
+	releases   map[string]*release
+	failures   []int
+	interrupts []int64
+	requests   []Request
+
+	inFlight    int
+	maxInFlight int
+}
+
+// Request is a request recorded by the Server.
+type Request struct {
+	Method  string
+	Edition string
+	Suffix  string
+	Header  http.Header
+	// Status is the status code the Server answered with.
+	Status int
+}
+
+type release struct {
+	archive  []byte
+	checksum string
+	etag     string
+	modTime  time.Time
+	filename string
+}
+
+// NewServer starts a Server accepting the given credentials.
+// The caller must call Close when finished.
+func NewServer(accountID, licenseKey string) *Server {
+	s := &Server{
+		AccountID:  accountID,
+		LicenseKey: licenseKey,
+		releases:   make(map[string]*release),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /geoip/databases/{edition}/download", s.handleDownload)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// DownloadURL returns the URL template to pass to mmdb.WithURL.
+func (s *Server) DownloadURL() string {
+	return s.URL + "/geoip/databases/%s/download?suffix=tar.gz"
+}
+
+// SetDatabase publishes data as the given edition, packed into a tar.gz
+// archive the way MaxMind does, with modTime as its Last-Modified time.
+func (s *Server) SetDatabase(edition string, data []byte, modTime time.Time) {
+	archive, err := Archive(edition, modTime, map[string][]byte{
+		edition + ".mmdb": data,
+		"LICENSE.txt":     []byte("Use of this MaxMind product is governed by MaxMind's GeoLite2 End User License Agreement.\n"),
+		"COPYRIGHT.txt":   []byte("Database and Contents Copyright (c) MaxMind, Inc.\n"),
+	})
+	if err != nil {
+		panic(err)
+	}
+	s.SetArchive(edition, archive, modTime)
+}
+
+// SetArchive publishes archive as-is for the given edition. Use it to serve
+// malformed or unusual archives.
+func (s *Server) SetArchive(edition string, archive []byte, modTime time.Time) {
+	sum := sha256.Sum256(archive)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releases[edition] = &release{
+		archive:  archive,
+		checksum: hex.EncodeToString(sum[:]),
+		etag:     `"` + hex.EncodeToString(sum[:8]) + `"`,
+		modTime:  modTime.UTC().Truncate(time.Second),
+		filename: archiveName(edition, modTime),
+	}
+}
+
+// SetChecksum overrides the published checksum of an edition.
+func (s *Server) SetChecksum(edition, checksum string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.releases[edition]; ok {
+		e.checksum = checksum
+	}
+}
+
+// FailNext makes the next len(statuses) requests fail with the given
+// status codes, in order. A status of 0 serves that request normally.
+func (s *Server) FailNext(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statuses...)
+}
+
+// InterruptNext makes the next archive downloads break off after the given
+// number of body bytes, in order, by aborting the connection.
+func (s *Server) InterruptNext(after ...int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interrupts = append(s.interrupts, after...)
+}
+
+// MaxInFlight returns the highest number of requests served at once.
+func (s *Server) MaxInFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxInFlight
+}
+
+// Requests returns all requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// RequestCount returns how many requests of the given method were made for
+// an edition.
+func (s *Server) RequestCount(method, edition string) int {
+	n := 0
+	for _, r := range s.Requests() {
+		if r.Method == method && r.Edition == edition {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	rec := Request{
+		Method:  r.Method,
+		Edition: r.PathValue("edition"),
+		Suffix:  r.URL.Query().Get("suffix"),
+		Header:  r.Header.Clone(),
+	}
+
+	s.mu.Lock()
+	s.inFlight++
+	s.maxInFlight = max(s.maxInFlight, s.inFlight)
+	delay := s.Delay
+	var failure int
+	if len(s.failures) > 0 {
+		failure = s.failures[0]
+		s.failures = s.failures[1:]
+	}
+	e := s.releases[rec.Edition]
+	s.mu.Unlock()
+
+	sw := &statusWriter{ResponseWriter: w, limit: -1}
+	defer func() {
+		rec.Status = sw.status
+		s.mu.Lock()
+		s.inFlight--
+		s.requests = append(s.requests, rec)
+		s.mu.Unlock()
+	}()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	if rec.Suffix == "tar.gz" && r.Method == http.MethodGet {
+		s.mu.Lock()
+		if len(s.interrupts) > 0 {
+			sw.limit = s.interrupts[0]
+			s.interrupts = s.interrupts[1:]
+		}
+		s.mu.Unlock()
+	}
+
+	s.serve(sw, r, rec, e, failure)
+}
+
+// statusWriter records the response status and optionally aborts the
+// connection once limit body bytes have been written.
+type statusWriter struct {
+	http.ResponseWriter
+	status  int
+	limit   int64
+	written int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.limit >= 0 && w.written+int64(len(p)) > w.limit {
+		n, _ := w.ResponseWriter.Write(p[:w.limit-w.written])
+		w.written += int64(n)
+		if f, ok := w.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+		panic(http.ErrAbortHandler)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// serve writes the response to a download request.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, rec Request, e *release, failure int) {
+	account, license, ok := r.BasicAuth()
+	if !ok || account != s.AccountID || license != s.LicenseKey {
+		http.Error(w, `{"code":"AUTHORIZATION_INVALID","error":"Invalid account ID or license key"}`, http.StatusUnauthorized)
+		return
+	}
+
+	if failure != 0 {
+		if failure == http.StatusTooManyRequests && s.RetryAfter != "" {
+			w.Header().Set("Retry-After", s.RetryAfter)
+		}
+		http.Error(w, http.StatusText(failure), failure)
+		return
+	}
+
+	if e == nil {
+		http.Error(w, `{"code":"NOT_FOUND","error":"Database edition not found"}`, http.StatusNotFound)
+		return
+	}
+
+	switch rec.Suffix {
+	case "tar.gz":
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", "attachment; filename="+e.filename)
+		w.Header().Set("ETag", e.etag)
+		// ServeContent handles HEAD, conditional and Range requests
+		http.ServeContent(w, r, e.filename, e.modTime, bytes.NewReader(e.archive))
+	case "tar.gz.sha256":
+		body := fmt.Sprintf("%s  %s\n", e.checksum, e.filename)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Disposition", "attachment; filename="+e.filename+".sha256")
+		w.Header().Set("Last-Modified", e.modTime.Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			io.WriteString(w, body)
+		}
+	default:
+		http.Error(w, `{"code":"INVALID_SUFFIX","error":"Invalid suffix"}`, http.StatusBadRequest)
+	}
+}
+
+// Archive packs files into a tar.gz archive below a directory named like
+// MaxMind's, e.g. GeoLite2-City_20231114/.
+func Archive(edition string, modTime time.Time, files map[string][]byte) ([]byte, error) {
+	dir := edition + "_" + modTime.UTC().Format("20060102")
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     dir + "/",
+		Mode:     0o755,
+		ModTime:  modTime,
+	}); err != nil {
+		return nil, err
+	}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		data := files[name]
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     dir + "/" + name,
+			Mode:     0o644,
+			Size:     int64(len(data)),
+			ModTime:  modTime,
+		}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func archiveName(edition string, modTime time.Time) string {
+	return edition + "_" + modTime.UTC().Format("20060102") + ".tar.gz"
+}
@@ -0,0 +1,44 @@
+package mmdb
+
+import "sync"
+
+// Listener is notified by a Downloader after it replaced the database file
+// of an edition, e.g. to reload it. A Client is a Listener.
+type Listener interface {
+	DatabaseUpdated(edition string)
+}
+
+// ListenerFunc adapts a function to a Listener.
+type ListenerFunc func(edition string)
+
+func (f ListenerFunc) DatabaseUpdated(edition string) {
+	f(edition)
+}
+
+// listeners is the set of Listeners of a Downloader; downloads run
+// concurrently, so it is guarded by a mutex.
+type listeners struct {
+	mu   sync.Mutex
+	list []Listener
+}
+
+// AddListener registers l to be notified after each database the
+// Downloader installs, by download or rollback. Listeners are called
+// synchronously, in the order they were added.
+func (d *Downloader) AddListener(l Listener) {
+	d.listeners.mu.Lock()
+	defer d.listeners.mu.Unlock()
+	d.listeners.list = append(d.listeners.list, l)
+}
+
+// notify tells the listeners that edition was replaced.
+func (d *Downloader) notify(edition string) {
+	DatabaseUpdatesTotal.WithLabelValues(edition).Inc()
+
+	d.listeners.mu.Lock()
+	list := d.listeners.list
+	d.listeners.mu.Unlock()
+	for _, l := range list {
+		l.DatabaseUpdated(edition)
+	}
+}
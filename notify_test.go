@@ -0,0 +1,92 @@
+package mmdb
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNotify(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	ctx := context.Background()
+
+	var updated []string
+	d.AddListener(ListenerFunc(func(edition string) {
+		updated = append(updated, edition)
+	}))
+	before := testutil.ToFloat64(DatabaseUpdatesTotal.WithLabelValues(CityDatabase))
+
+	if err := d.downloadOne(ctx, CityDatabase).Err; err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	// skipped and failed downloads replace nothing
+	if err := d.downloadOne(ctx, CityDatabase).Err; err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	publishRelease(t, srv, CityDatabase, testBuildEpoch+3600)
+	srv.SetChecksum(CityDatabase, "0000000000000000000000000000000000000000000000000000000000000000")
+	if res := d.downloadOne(ctx, CityDatabase); res.Status != StatusChecksumMismatch {
+		t.Fatalf("expected checksum mismatch, got %s: %v", res.Status, res.Err)
+	}
+
+	if expected := []string{CityDatabase}; !reflect.DeepEqual(updated, expected) {
+		t.Errorf("expected notifications %v, got %v", expected, updated)
+	}
+	if got := testutil.ToFloat64(DatabaseUpdatesTotal.WithLabelValues(CityDatabase)) - before; got != 1 {
+		t.Errorf("expected updates counter to increase by 1, got %v", got)
+	}
+}
+
+func TestNotifyClient(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	ctx := context.Background()
+	if _, err := d.DownloadDatabases(ctx); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+
+	t.Setenv(MaxmindPinnedGenerations, "")
+	c, err := NewClient()
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close()
+	d.AddListener(c)
+
+	publishRelease(t, srv, CityDatabase, testBuildEpoch+3600)
+	if res := d.downloadOne(ctx, CityDatabase); res.Err != nil || res.Status != StatusSuccess {
+		t.Fatalf("expected new release, got %s: %v", res.Status, res.Err)
+	}
+	if got := c.CityDB().Metadata.BuildEpoch; got != testBuildEpoch+3600 {
+		t.Errorf("expected client to reload build %d, got %d", testBuildEpoch+3600, got)
+	}
+
+	if _, err := d.Rollback(CityDatabase, 0); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if got := c.CityDB().Metadata.BuildEpoch; got != testBuildEpoch {
+		t.Errorf("expected client to reload rolled back build %d, got %d", testBuildEpoch, got)
+	}
+}
+
+func TestClientDatabaseUpdatedAfterClose(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDatabases(t, dir, testBuildEpoch)
+	t.Setenv(MaxmindBasePath, dir)
+	t.Setenv(MaxmindEditionIds, "")
+	t.Setenv(MaxmindPinnedGenerations, "")
+	c, err := NewClient()
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	c.Close()
+
+	// a late notification must not reopen the database
+	c.DatabaseUpdated(CityDatabase)
+	var rec any
+	if err := c.CityDB().Lookup(net.ParseIP("81.2.69.142"), &rec); err == nil {
+		t.Error("expected closed reader after Close, got open one")
+	}
+}
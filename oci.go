@@ -0,0 +1,341 @@
+package mmdb
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Media types of the manifests an OCISource accepts.
+const (
+	ociManifest    = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// OCISource fetches archives or databases pushed as artifacts to an OCI
+// distribution registry, e.g. with
+// "oras push registry.example.com/geoip:GeoLite2-City GeoLite2-City.tar.gz".
+// It pulls the manifest tagged for the edition and then fetches its layer
+// by digest, which doubles as the checksum of the archive. Registries
+// asking for a bearer token get one from their token service, with
+// Username and Password if set.
+type OCISource struct {
+	// Registry is the base URL of the registry, e.g. https://ghcr.io.
+	Registry string
+	// Repository is the repository name, e.g. "acme/geoip". A %s is
+	// replaced by the edition in lower case, as repository names are.
+	Repository string
+	// Tag is the tag of the artifact, %s being the edition. If empty, the
+	// edition itself is the tag.
+	Tag string
+
+	Username string
+	Password string
+
+	// Client is used when the source is used on its own and defaults to
+	// http.DefaultClient. A Downloader sends the requests with its own
+	// client instead, like for an S3Source.
+	Client *http.Client
+
+	mu    sync.Mutex
+	token string
+}
+
+// ociDescriptor describes a blob referenced by a manifest.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type ociImageManifest struct {
+	MediaType   string            `json:"mediaType"`
+	Layers      []ociDescriptor   `json:"layers"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+func (s *OCISource) repository(edition string) string {
+	if strings.Contains(s.Repository, "%s") {
+		return fmt.Sprintf(s.Repository, strings.ToLower(edition))
+	}
+	return s.Repository
+}
+
+func (s *OCISource) tag(edition string) string {
+	if s.Tag == "" {
+		return edition
+	}
+	if strings.Contains(s.Tag, "%s") {
+		return fmt.Sprintf(s.Tag, edition)
+	}
+	return s.Tag
+}
+
+func (s *OCISource) url(repository, kind, ref string) string {
+	return strings.TrimSuffix(s.Registry, "/") + "/v2/" + repository + "/" + kind + "/" + ref
+}
+
+// send sends a request with Client, without retries.
+func (s *OCISource) send(ctx context.Context, method, url string, header http.Header, prepare func(*http.Request)) (*http.Response, error) {
+	return sendOnce(ctx, s.Client, method, url, header, prepare)
+}
+
+// authorize sets the bearer token on req, or the key pair if there is no
+// token yet.
+func (s *OCISource) authorize(req *http.Request) {
+	s.mu.Lock()
+	token := s.token
+	s.mu.Unlock()
+	switch {
+	case token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	case s.Username != "":
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+}
+
+// get requests url with GET or HEAD. If the registry asks for a bearer
+// token, it fetches one and tries again.
+func (s *OCISource) get(ctx context.Context, send sendFunc, method, url, repository string, header http.Header) (*http.Response, error) {
+	resp, err := send(ctx, method, url, header, s.authorize)
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	realm, params, ok := parseBearerChallenge(se.challenge)
+	if !ok {
+		return nil, err
+	}
+	if err := s.fetchToken(ctx, send, realm, params, repository); err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+	return send(ctx, method, url, header, s.authorize)
+}
+
+// fetchToken gets a pull token for repository from the token service at
+// realm, see https://distribution.github.io/distribution/spec/auth/token/.
+func (s *OCISource) fetchToken(ctx context.Context, send sendFunc, realm string, params map[string]string, repository string) error {
+	u, err := neturl.Parse(realm)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	if service := params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + repository + ":pull"
+	}
+	q.Set("scope", scope)
+	u.RawQuery = q.Encode()
+
+	resp, err := send(ctx, http.MethodGet, u.String(), nil, func(req *http.Request) {
+		if s.Username != "" {
+			req.SetBasicAuth(s.Username, s.Password)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body); err != nil {
+		return err
+	}
+	token := cmp.Or(body.Token, body.AccessToken)
+	if token == "" {
+		return fmt.Errorf("no token in response")
+	}
+	s.mu.Lock()
+	s.token = token
+	s.mu.Unlock()
+	return nil
+}
+
+// parseBearerChallenge parses a WWW-Authenticate header of the form
+// Bearer realm="...",service="...",scope="...".
+func parseBearerChallenge(challenge string) (realm string, params map[string]string, ok bool) {
+	scheme, rest, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", nil, false
+	}
+	params = make(map[string]string)
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+			params[key], rest = value[1:end+1], value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return params["realm"], params, params["realm"] != ""
+}
+
+// manifest requests the manifest of edition with method, returning the
+// response and the release it describes, or ErrNotModified if its digest
+// is the ETag of have. A HEAD response has no body.
+func (s *OCISource) manifest(ctx context.Context, send sendFunc, method, edition string, have Release) (*http.Response, Release, error) {
+	repository := s.repository(edition)
+	header := http.Header{"Accept": {ociManifest + ", " + dockerManifest}}
+	resp, err := s.get(ctx, send, method, s.url(repository, "manifests", s.tag(edition)), repository, header)
+	if err != nil {
+		return nil, Release{}, err
+	}
+	rel := Release{ETag: resp.Header.Get("Docker-Content-Digest")}
+	rel.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	if rel.ETag != "" && rel.ETag == have.ETag {
+		resp.Body.Close()
+		return nil, Release{}, ErrNotModified
+	}
+	return resp, rel, nil
+}
+
+func (s *OCISource) Check(ctx context.Context, edition string, have Release) (Release, error) {
+	return s.check(ctx, s.send, edition, have)
+}
+
+func (s *OCISource) Fetch(ctx context.Context, edition string, have Release, dst string) (Release, error) {
+	return s.fetch(ctx, s.send, edition, have, dst)
+}
+
+func (s *OCISource) checkWith(ctx context.Context, d *Downloader, edition string, have Release) (Release, error) {
+	return s.check(ctx, d.send, edition, have)
+}
+
+func (s *OCISource) fetchWith(ctx context.Context, d *Downloader, edition string, have Release, dst string) (Release, error) {
+	return s.fetch(ctx, d.send, edition, have, dst)
+}
+
+func (s *OCISource) check(ctx context.Context, send sendFunc, edition string, have Release) (Release, error) {
+	resp, rel, err := s.manifest(ctx, send, http.MethodHead, edition, have)
+	if err != nil {
+		return Release{}, err
+	}
+	resp.Body.Close()
+	if rel.ETag != "" {
+		return rel, nil
+	}
+	// without a digest header, the digest is that of the manifest itself
+	resp, rel, err = s.manifest(ctx, send, http.MethodGet, edition, have)
+	if err != nil {
+		return Release{}, err
+	}
+	defer resp.Body.Close()
+	_, rel, err = readManifest(resp.Body, rel, have)
+	return rel, err
+}
+
+// readManifest parses the manifest in body, completing rel with its digest
+// if the registry sent none and the creation time of its annotations.
+func readManifest(body io.Reader, rel, have Release) (ociImageManifest, Release, error) {
+	var m ociImageManifest
+	data, err := io.ReadAll(io.LimitReader(body, 4<<20))
+	if err != nil {
+		return m, rel, fmt.Errorf("manifest: %w", err)
+	}
+	if rel.ETag == "" {
+		sum := sha256.Sum256(data)
+		rel.ETag = "sha256:" + hex.EncodeToString(sum[:])
+		if rel.ETag == have.ETag {
+			return m, Release{}, ErrNotModified
+		}
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, rel, fmt.Errorf("manifest: %w", err)
+	}
+	if m.MediaType != "" && m.MediaType != ociManifest && m.MediaType != dockerManifest {
+		return m, rel, fmt.Errorf("manifest: unsupported media type %s", m.MediaType)
+	}
+	if created, err := time.Parse(time.RFC3339, m.Annotations["org.opencontainers.image.created"]); err == nil {
+		rel.LastModified = created
+	}
+	return m, rel, nil
+}
+
+// layer returns the layer of m holding the archive of edition: the only
+// one, or the one whose title starts with the edition.
+func (m ociImageManifest) layer(edition string) (ociDescriptor, error) {
+	if len(m.Layers) == 1 {
+		return m.Layers[0], nil
+	}
+	for _, l := range m.Layers {
+		if strings.HasPrefix(l.Annotations["org.opencontainers.image.title"], edition) {
+			return l, nil
+		}
+	}
+	return ociDescriptor{}, fmt.Errorf("manifest: no layer for %s among %d", edition, len(m.Layers))
+}
+
+// fetch writes the layer of edition to dst. On error, dst is removed, so
+// that no truncated blob is taken for a partial download.
+func (s *OCISource) fetch(ctx context.Context, send sendFunc, edition string, have Release, dst string) (_ Release, err error) {
+	resp, rel, err := s.manifest(ctx, send, http.MethodGet, edition, have)
+	if err != nil {
+		return Release{}, err
+	}
+	m, rel, err := readManifest(resp.Body, rel, have)
+	resp.Body.Close()
+	if err != nil {
+		return Release{}, err
+	}
+	layer, err := m.layer(edition)
+	if err != nil {
+		return Release{}, err
+	}
+	rel.Name = layer.Annotations["org.opencontainers.image.title"]
+	if sum, ok := strings.CutPrefix(layer.Digest, "sha256:"); ok {
+		rel.SHA256 = sum
+	}
+
+	repository := s.repository(edition)
+	blob, err := s.get(ctx, send, http.MethodGet, s.url(repository, "blobs", layer.Digest), repository, nil)
+	if err != nil {
+		return rel, err
+	}
+	defer blob.Body.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return rel, err
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+	rel.Bytes, err = io.Copy(f, blob.Body)
+	DownloadBytesTotal.WithLabelValues(edition).Add(float64(rel.Bytes))
+	if err != nil {
+		return rel, err
+	}
+	if rel.Bytes != layer.Size {
+		return rel, fmt.Errorf("short transfer: got %d of %d bytes", rel.Bytes, layer.Size)
+	}
+	return rel, f.Close()
+}
@@ -0,0 +1,128 @@
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NoUmlautsAllowed/go-mmdb/mmdbtest"
+)
+
+func TestOCISource(t *testing.T) {
+	registry := mmdbtest.NewRegistry("reader", "registry-secret")
+	defer registry.Close()
+
+	archive, err := mmdbtest.Archive(ASNDatabase, testModTime, map[string][]byte{
+		ASNDatabase + ".mmdb": mmdbtest.DefaultDatabase(ASNDatabase),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.PushArtifact("acme/geoip", ASNDatabase, ASNDatabase+".tar.gz", archive, testModTime)
+
+	src := &OCISource{
+		Registry:   registry.URL,
+		Repository: "acme/geoip",
+		Username:   "reader",
+		Password:   "registry-secret",
+	}
+	d := newSourceDownloader(t, WithSource(src))
+	ctx := context.Background()
+
+	res := d.downloadOne(ctx, ASNDatabase)
+	if res.Err != nil || res.Status != StatusSuccess {
+		t.Fatalf("expected download from the registry, got %s: %v", res.Status, res.Err)
+	}
+	if !res.RemoteTime.Equal(testModTime) {
+		t.Errorf("expected the creation time %s, got %s", testModTime, res.RemoteTime)
+	}
+	for _, r := range registry.Requests() {
+		if strings.Contains(r.Edition, "/blobs/") && r.Header.Get("Authorization") == "" {
+			t.Errorf("expected blob request with the bearer token, got %v", r.Header)
+		}
+	}
+	if res := d.downloadOne(ctx, ASNDatabase); res.Err != nil || res.Status != StatusSkipped {
+		t.Fatalf("expected unchanged manifest to be skipped, got %s: %v", res.Status, res.Err)
+	}
+	if _, err := src.Check(ctx, ASNDatabase, loadState(d.BasePath, ASNDatabase).release()); !errors.Is(err, ErrNotModified) {
+		t.Errorf("expected check of the unchanged manifest to return ErrNotModified, got %v", err)
+	}
+
+	// a new push is a new release
+	newData, err := mmdbtest.Database(ASNDatabase, testBuildEpoch+3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := registry.PushArtifact("acme/geoip", ASNDatabase, ASNDatabase+".mmdb", newData, testModTime.Add(time.Hour))
+	if rel, err := src.Check(ctx, ASNDatabase, loadState(d.BasePath, ASNDatabase).release()); err != nil || rel.ETag != digest {
+		t.Errorf("expected release %s, got %+v, %v", digest, rel, err)
+	}
+	if res := d.downloadOne(ctx, ASNDatabase); res.Err != nil || res.Status != StatusSuccess {
+		t.Fatalf("expected new artifact to be installed, got %s: %v", res.Status, res.Err)
+	}
+	if epoch, err := buildEpoch(dbPath(d.BasePath, ASNDatabase)); err != nil || epoch != testBuildEpoch+3600 {
+		t.Errorf("expected build %d, got %d, %v", testBuildEpoch+3600, epoch, err)
+	}
+
+	// a missing tag and bad credentials fail
+	if res := d.downloadOne(ctx, CityDatabase); res.Err == nil {
+		t.Error("expected error for an edition without an artifact")
+	}
+	bad := newSourceDownloader(t, WithSource(&OCISource{
+		Registry: registry.URL, Repository: "acme/geoip", Username: "reader", Password: "wrong",
+	}))
+	if res := bad.downloadOne(ctx, ASNDatabase); !errors.Is(res.Err, ErrUnauthorized) || strings.Contains(res.Err.Error(), "wrong") {
+		t.Errorf("expected redacted ErrUnauthorized, got %v", res.Err)
+	}
+}
+
+func TestOCISourceInterrupted(t *testing.T) {
+	registry := mmdbtest.NewRegistry("", "")
+	defer registry.Close()
+	registry.PushArtifact("geoip/geolite2-asn", "latest", ASNDatabase+".mmdb", mmdbtest.DefaultDatabase(ASNDatabase), testModTime)
+
+	src := &OCISource{Registry: registry.URL, Repository: "geoip/%s", Tag: "latest"}
+	dst := filepath.Join(t.TempDir(), "GeoLite2-ASN.part")
+	registry.InterruptNext(100)
+
+	if _, err := src.Fetch(context.Background(), ASNDatabase, Release{}, dst); err == nil {
+		t.Fatal("expected error for an interrupted transfer")
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("expected the truncated blob to be removed, got %v", err)
+	}
+
+	rel, err := src.Fetch(context.Background(), ASNDatabase, Release{}, dst)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if rel.Name != ASNDatabase+".mmdb" || len(rel.SHA256) != 64 {
+		t.Errorf("expected name and digest of the layer, got %+v", rel)
+	}
+	for _, r := range registry.Requests() {
+		if r.Status == http.StatusUnauthorized {
+			t.Errorf("expected anonymous pulls without a token, got %s %s", r.Method, r.Edition)
+		}
+	}
+}
+
+func TestParseBearerChallenge(t *testing.T) {
+	realm, params, ok := parseBearerChallenge(`Bearer realm="https://auth.example.com/token",service="registry",scope="repository:acme/geoip:pull,push"`)
+	expected := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry",
+		"scope":   "repository:acme/geoip:pull,push",
+	}
+	if !ok || realm != expected["realm"] || !reflect.DeepEqual(params, expected) {
+		t.Errorf("expected %v, got %q %v %v", expected, realm, params, ok)
+	}
+	if _, _, ok := parseBearerChallenge(`Basic realm="registry"`); ok {
+		t.Error("expected basic challenge to be rejected")
+	}
+}
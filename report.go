@@ -0,0 +1,97 @@
+package mmdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// DownloadStatus is the outcome of downloading a single edition. Its values
+// are also used as the status label of mmdb_download_total.
+type DownloadStatus string
+
+const (
+	StatusSuccess          DownloadStatus = "success"
+	StatusFailure          DownloadStatus = "failure"
+	StatusChecksumMismatch DownloadStatus = "checksum_mismatch"
+	StatusSkipped          DownloadStatus = "skipped"
+	// StatusLocked means another process was downloading the edition.
+	StatusLocked DownloadStatus = "locked"
+)
+
+// DownloadResult describes the download of a single edition.
+type DownloadResult struct {
+	Edition string
+	Status  DownloadStatus
+	// Bytes is the size of the archive received, 0 if none was fetched.
+	Bytes int64
+	// RemoteTime is the Last-Modified time reported for the edition.
+	RemoteTime time.Time
+	Duration   time.Duration
+	Err        error
+}
+
+// DownloadReport collects the results of one DownloadDatabases run in the
+// order the editions were requested.
+type DownloadReport struct {
+	Results []DownloadResult
+}
+
+// Failed returns the results that ended with an error.
+func (r *DownloadReport) Failed() []DownloadResult {
+	var failed []DownloadResult
+	for _, res := range r.Results {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Err joins the errors of all failed editions, prefixed with the edition.
+// It returns nil if every edition succeeded or was skipped.
+func (r *DownloadReport) Err() error {
+	var errs []error
+	for _, res := range r.Failed() {
+		errs = append(errs, fmt.Errorf("%s: %w", res.Edition, res.Err))
+	}
+	return errors.Join(errs...)
+}
+
+// String renders the report as a table, one line per edition.
+func (r *DownloadReport) String() string {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "EDITION\tSTATUS\tSIZE\tBUILD\tDURATION\tERROR")
+	for _, res := range r.Results {
+		build := "-"
+		if !res.RemoteTime.IsZero() {
+			build = res.RemoteTime.UTC().Format(time.DateOnly)
+		}
+		errMsg := ""
+		if res.Err != nil {
+			errMsg = res.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			res.Edition, res.Status, formatBytes(res.Bytes), build,
+			res.Duration.Round(time.Millisecond), errMsg)
+	}
+	tw.Flush()
+	return b.String()
+}
+
+// formatBytes formats n using binary units, e.g. "31.4 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,67 @@
+package mmdb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadReport(t *testing.T) {
+	report := &DownloadReport{Results: []DownloadResult{
+		{Edition: CityDatabase, Status: StatusSuccess, Bytes: 3 << 20, RemoteTime: testModTime, Duration: 1500 * time.Millisecond},
+		{Edition: ASNDatabase, Status: StatusSkipped, RemoteTime: testModTime},
+	}}
+	if err := report.Err(); err != nil {
+		t.Errorf("expected no error without failures, got %v", err)
+	}
+
+	report.Results = append(report.Results, DownloadResult{
+		Edition: CountryDatabase,
+		Status:  StatusChecksumMismatch,
+		Err:     ErrChecksumMismatch,
+	})
+	err := report.Err()
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected joined error to wrap ErrChecksumMismatch, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), CountryDatabase+": ") {
+		t.Errorf("expected error prefixed with edition, got %q", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected header and 3 rows, got %q", report.String())
+	}
+	for i, want := range [][]string{
+		{"EDITION", "STATUS", "ERROR"},
+		{CityDatabase, "success", "3.0 MiB", "2023-11-14", "1.5s"},
+		{ASNDatabase, "skipped", "0 B"},
+		{CountryDatabase, "checksum_mismatch", "-", "checksum mismatch"},
+	} {
+		for _, field := range want {
+			if !strings.Contains(lines[i], field) {
+				t.Errorf("line %d: expected %q in %q", i, field, lines[i])
+			}
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n        int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{31 << 20, "31.0 MiB"},
+		{5 << 30, "5.0 GiB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.expected {
+			t.Errorf("formatBytes(%d): expected %q, got %q", tt.n, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrUnauthorized is returned when the download server rejects the
+// credentials. Such requests are never retried.
+var ErrUnauthorized = errors.New("mmdb: invalid account ID or license key")
+
+// RetryPolicy controls how failed requests to the download server are
+// retried. Network errors, 408, 429 and 5xx responses are retried with
+// exponential backoff and jitter; a Retry-After header replaces the backoff
+// unless it asks to wait longer than MaxBackoff, in which case the request
+// fails right away.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	// MaxElapsed, if set, stops retrying once this much time has passed
+	// since the first attempt, like geoipupdate's RetryFor.
+	MaxElapsed time.Duration
+}
+
+// retryBudget counts the attempts made for one request, shared by the
+// retries of a transfer and the resumes after it is interrupted.
+type retryBudget struct {
+	start    time.Time
+	attempts int
+}
+
+func newRetryBudget() *retryBudget {
+	return &retryBudget{start: time.Now()}
+}
+
+// exhausted reports whether no more attempts may start within b.
+func (p RetryPolicy) exhausted(b *retryBudget) bool {
+	if b.attempts == 0 {
+		return false
+	}
+	return b.attempts >= max(p.MaxAttempts, 1) ||
+		p.MaxElapsed > 0 && time.Since(b.start) >= p.MaxElapsed
+}
+
+// DefaultRetryPolicy is used unless WithRetryPolicy is given.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	MinBackoff:  2 * time.Second,
+	MaxBackoff:  2 * time.Minute,
+}
+
+// WithRetryPolicy sets how failed requests are retried.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(d *Downloader) {
+		d.retry = p
+	}
+}
+
+// StatusError is returned for non-2xx responses from the download server.
+type StatusError struct {
+	Method     string
+	StatusCode int
+	// RetryAfter is the parsed Retry-After header, 0 if absent.
+	RetryAfter time.Duration
+
+	// challenge is the WWW-Authenticate header, e.g. of a registry
+	// asking for a bearer token.
+	challenge string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s status %d", e.Method, e.StatusCode)
+}
+
+func (e *StatusError) Unwrap() error {
+	if e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// retryable reports whether a request failing with status should be tried
+// again.
+func retryable(status int) bool {
+	return status == http.StatusRequestTimeout ||
+		status == http.StatusTooManyRequests ||
+		status >= 500
+}
+
+// do sends an authenticated request with the given extra header, retrying
+// according to the retry policy. 2xx and 304 responses are returned; the
+// caller must close their body.
+func (d *Downloader) do(ctx context.Context, method, url string, header http.Header) (*http.Response, error) {
+	return d.send(ctx, method, url, header, nil)
+}
+
+// send is like do, calling prepare, if not nil, on the request of each
+// attempt before it is sent, e.g. to sign it.
+func (d *Downloader) send(ctx context.Context, method, url string, header http.Header, prepare func(*http.Request)) (*http.Response, error) {
+	return d.sendWithin(ctx, newRetryBudget(), method, url, header, prepare)
+}
+
+// sendWithin is like send, taking its attempts from b. The first attempt is
+// made even if b is exhausted; callers check b before.
+func (d *Downloader) sendWithin(ctx context.Context, b *retryBudget, method, url string, header http.Header, prepare func(*http.Request)) (*http.Response, error) {
+	var lastErr error
+	for retry := false; !retry || !d.retry.exhausted(b); retry = true {
+		if retry {
+			// a Retry-After pauses the shared limiter instead, so that
+			// parallel downloads hold back as well
+			var se *StatusError
+			if !errors.As(lastErr, &se) || se.RetryAfter <= 0 {
+				if err := d.sleep(ctx, d.retry.backoff(b.attempts)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := d.limiter.wait(ctx, d.sleep); err != nil {
+			return nil, err
+		}
+		b.attempts++
+
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if prepare != nil {
+			prepare(req)
+		}
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			DownloadResponsesTotal.WithLabelValues(method, "error").Inc()
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			lastErr = err
+			DownloadRetriesTotal.WithLabelValues("error").Inc()
+			continue
+		}
+
+		code := strconv.Itoa(resp.StatusCode)
+		DownloadResponsesTotal.WithLabelValues(method, code).Inc()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 || resp.StatusCode == http.StatusNotModified {
+			return resp, nil
+		}
+
+		discard(resp.Body)
+
+		se := &StatusError{
+			Method:     method,
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			challenge:  resp.Header.Get("WWW-Authenticate"),
+		}
+		if !retryable(resp.StatusCode) {
+			return nil, se
+		}
+		if se.RetryAfter > d.retry.MaxBackoff {
+			return nil, fmt.Errorf("%w (retry after %s)", se, se.RetryAfter)
+		}
+		if se.RetryAfter > 0 {
+			d.limiter.pause(se.RetryAfter)
+		}
+		lastErr = se
+		DownloadRetriesTotal.WithLabelValues(code).Inc()
+	}
+
+	return nil, fmt.Errorf("giving up after %d attempts: %w", b.attempts, lastErr)
+}
+
+// backoff returns the wait before the given retry (1 for the first retry):
+// MinBackoff doubled per attempt, capped at MaxBackoff, with the upper half
+// randomized.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.MinBackoff << (attempt - 1)
+	if wait > p.MaxBackoff || wait <= 0 {
+		wait = p.MaxBackoff
+	}
+	half := wait / 2
+	if half <= 0 {
+		return wait
+	}
+	return half + rand.N(half+1)
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as
+// an HTTP date. It returns 0 if the header is absent or invalid.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if wait := t.Sub(now); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
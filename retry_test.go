@@ -0,0 +1,240 @@
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// recordSleeps makes d record its waits instead of sleeping.
+func recordSleeps(d *Downloader) *[]time.Duration {
+	var waits []time.Duration
+	d.sleep = func(ctx context.Context, wait time.Duration) error {
+		waits = append(waits, wait)
+		return ctx.Err()
+	}
+	return &waits
+}
+
+func TestDoRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		fail         []int
+		retryAfter   string
+		wantErr      error
+		wantRequests int
+		wantWaits    []time.Duration
+		checkBackoff bool
+	}{
+		{
+			name:         "Success After Rate Limit",
+			fail:         []int{429, 429},
+			wantRequests: 3,
+			checkBackoff: true,
+		},
+		{
+			name:         "Success After Server Error",
+			fail:         []int{503},
+			wantRequests: 2,
+			checkBackoff: true,
+		},
+		{
+			name:         "Retry-After Seconds",
+			fail:         []int{429},
+			retryAfter:   "7",
+			wantRequests: 2,
+			wantWaits:    []time.Duration{7 * time.Second},
+		},
+		{
+			name:         "Retry-After Beyond Max Backoff",
+			fail:         []int{429},
+			retryAfter:   "86400",
+			wantErr:      &StatusError{},
+			wantRequests: 1,
+		},
+		{
+			name:         "Unauthorized Is Not Retried",
+			fail:         []int{401},
+			wantErr:      ErrUnauthorized,
+			wantRequests: 1,
+		},
+		{
+			name:         "Client Error Is Not Retried",
+			fail:         []int{400},
+			wantErr:      &StatusError{},
+			wantRequests: 1,
+		},
+		{
+			name:         "Gives Up",
+			fail:         []int{500, 502, 503, 504},
+			wantErr:      &StatusError{},
+			wantRequests: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, srv := newTestDownloader(t)
+			waits := recordSleeps(d)
+			srv.RetryAfter = tt.retryAfter
+			srv.FailNext(tt.fail...)
+
+			resp, err := d.do(context.Background(), http.MethodHead, srv.URL+"/geoip/databases/"+CityDatabase+"/download?suffix=tar.gz", testAuth(d))
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("expected success, got %v", err)
+				}
+			case *StatusError:
+				var se *StatusError
+				if !errors.As(err, &se) {
+					t.Fatalf("expected StatusError, got %v", err)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Fatalf("expected %v, got %v", want, err)
+				}
+			}
+
+			if n := len(srv.Requests()); n != tt.wantRequests {
+				t.Errorf("expected %d requests, got %d", tt.wantRequests, n)
+			}
+			if len(*waits) != tt.wantRequests-1 {
+				t.Errorf("expected %d waits, got %v", tt.wantRequests-1, *waits)
+			}
+			if tt.wantWaits != nil {
+				for i, want := range tt.wantWaits {
+					// the shared limiter measures from when the response arrived
+					if got := (*waits)[i]; got > want || got < want-time.Second {
+						t.Errorf("wait %d: expected %s, got %s", i, want, got)
+					}
+				}
+			}
+			if tt.checkBackoff {
+				for i, wait := range *waits {
+					if wait > testRetryPolicy.MaxBackoff || wait < testRetryPolicy.MinBackoff/2 {
+						t.Errorf("wait %d: %s outside of backoff bounds", i, wait)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDoCanceled(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	srv.FailNext(503, 503, 503)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.sleep = func(ctx context.Context, _ time.Duration) error {
+		cancel()
+		return ctx.Err()
+	}
+
+	_, err := d.do(ctx, http.MethodHead, srv.URL+"/geoip/databases/"+CityDatabase+"/download?suffix=tar.gz", testAuth(d))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if n := len(srv.Requests()); n != 1 {
+		t.Errorf("expected no request after cancellation, got %d", n)
+	}
+}
+
+func TestDoMaxElapsed(t *testing.T) {
+	policy := testRetryPolicy
+	policy.MaxElapsed = time.Nanosecond
+	d, srv := newTestDownloader(t, WithRetryPolicy(policy))
+	srv.FailNext(503, 503, 503)
+
+	_, err := d.do(context.Background(), http.MethodHead, srv.URL+"/geoip/databases/"+CityDatabase+"/download?suffix=tar.gz", testAuth(d))
+	var se *StatusError
+	if !errors.As(err, &se) {
+		t.Fatalf("expected StatusError, got %v", err)
+	}
+	if n := len(srv.Requests()); n != 1 {
+		t.Errorf("expected no retry after MaxElapsed, got %d requests", n)
+	}
+}
+
+func TestDoMetrics(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	srv.FailNext(429)
+
+	before429 := testutil.ToFloat64(DownloadResponsesTotal.WithLabelValues(http.MethodHead, "429"))
+	before200 := testutil.ToFloat64(DownloadResponsesTotal.WithLabelValues(http.MethodHead, "200"))
+	beforeRetries := testutil.ToFloat64(DownloadRetriesTotal.WithLabelValues("429"))
+
+	resp, err := d.do(context.Background(), http.MethodHead, srv.URL+"/geoip/databases/"+CityDatabase+"/download?suffix=tar.gz", testAuth(d))
+	if err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := testutil.ToFloat64(DownloadResponsesTotal.WithLabelValues(http.MethodHead, "429")) - before429; got != 1 {
+		t.Errorf("expected one 429 response counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(DownloadResponsesTotal.WithLabelValues(http.MethodHead, "200")) - before200; got != 1 {
+		t.Errorf("expected one 200 response counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(DownloadRetriesTotal.WithLabelValues("429")) - beforeRetries; got != 1 {
+		t.Errorf("expected one retry counted, got %v", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 10, MinBackoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	tests := []struct {
+		attempt int
+		lo, hi  time.Duration
+	}{
+		{1, 500 * time.Millisecond, time.Second},
+		{2, time.Second, 2 * time.Second},
+		{3, 2 * time.Second, 4 * time.Second},
+		{5, 5 * time.Second, 10 * time.Second},
+		{64, 5 * time.Second, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			if got := p.backoff(tt.attempt); got < tt.lo || got > tt.hi {
+				t.Fatalf("backoff(%d) = %s, expected within [%s, %s]", tt.attempt, got, tt.lo, tt.hi)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Hour).Format(http.TimeFormat), 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.expected {
+			t.Errorf("parseRetryAfter(%q): expected %s, got %s", tt.value, tt.expected, got)
+		}
+	}
+}
+
+// testAuth returns the auth header the Downloader's source sends to the
+// test server.
+func testAuth(d *Downloader) http.Header {
+	return d.source.(*httpSource).header(d.editionURL(CityDatabase))
+}
@@ -0,0 +1,181 @@
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/NoUmlautsAllowed/go-mmdb/internal/sigv4"
+)
+
+// S3Source fetches archives or databases from a bucket of an
+// S3-compatible object store such as AWS S3 or MinIO, using path-style
+// URLs and AWS Signature Version 4. A checksum is read from an object with
+// the same key and a .sha256 suffix if present.
+type S3Source struct {
+	// Endpoint is the base URL of the store, e.g. http://minio:9000.
+	// If empty, it is derived from Region for AWS S3.
+	Endpoint string
+	// Region defaults to us-east-1.
+	Region string
+	Bucket string
+	// Key is the object key, %s being the edition, e.g. "geoip/%s.mmdb".
+	Key string
+
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// Client is used when the source is used on its own and defaults to
+	// http.DefaultClient. A Downloader sends the requests with its own
+	// client instead, with its proxy, TLS and User-Agent settings, retries
+	// and rate limiting.
+	Client *http.Client
+}
+
+// sendFunc sends a request, calling prepare on it before, and returns the
+// response for 2xx and 304 and a *StatusError otherwise, see
+// Downloader.send.
+type sendFunc func(ctx context.Context, method, url string, header http.Header, prepare func(*http.Request)) (*http.Response, error)
+
+func (s *S3Source) region() string {
+	if s.Region == "" {
+		return "us-east-1"
+	}
+	return s.Region
+}
+
+func (s *S3Source) objectURL(key string) string {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + s.region() + ".amazonaws.com"
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/" + s.Bucket + "/" + key
+}
+
+// send sends a request with Client, without retries.
+func (s *S3Source) send(ctx context.Context, method, url string, header http.Header, prepare func(*http.Request)) (*http.Response, error) {
+	return sendOnce(ctx, s.Client, method, url, header, prepare)
+}
+
+// sign signs req with the key pair, if any.
+func (s *S3Source) sign(req *http.Request) {
+	if s.AccessKeyID != "" {
+		creds := sigv4.Credentials{AccessKeyID: s.AccessKeyID, SecretAccessKey: s.SecretAccessKey}
+		sigv4.Sign(req, creds, s.region(), "s3", time.Now())
+	}
+}
+
+// get requests an object with GET or HEAD, returning the response for 2xx
+// and 304 and a *StatusError otherwise.
+func (s *S3Source) get(ctx context.Context, send sendFunc, method, key string, header http.Header) (*http.Response, error) {
+	return send(ctx, method, s.objectURL(key), header, s.sign)
+}
+
+// request requests the object of edition unless it is the release have,
+// returning the response and the release it describes.
+func (s *S3Source) request(ctx context.Context, send sendFunc, method, edition string, have Release) (*http.Response, Release, error) {
+	key := fmt.Sprintf(s.Key, edition)
+
+	header := http.Header{}
+	if have.ETag != "" {
+		header.Set("If-None-Match", have.ETag)
+	}
+	resp, err := s.get(ctx, send, method, key, header)
+	if err != nil {
+		return nil, Release{}, err
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, Release{}, ErrNotModified
+	}
+
+	rel := Release{
+		ETag:        resp.Header.Get("ETag"),
+		Name:        path.Base(key),
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	rel.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return resp, rel, nil
+}
+
+func (s *S3Source) Check(ctx context.Context, edition string, have Release) (Release, error) {
+	return s.check(ctx, s.send, edition, have)
+}
+
+func (s *S3Source) Fetch(ctx context.Context, edition string, have Release, dst string) (Release, error) {
+	return s.fetch(ctx, s.send, edition, have, dst)
+}
+
+func (s *S3Source) checkWith(ctx context.Context, d *Downloader, edition string, have Release) (Release, error) {
+	return s.check(ctx, d.send, edition, have)
+}
+
+func (s *S3Source) fetchWith(ctx context.Context, d *Downloader, edition string, have Release, dst string) (Release, error) {
+	return s.fetch(ctx, d.send, edition, have, dst)
+}
+
+func (s *S3Source) check(ctx context.Context, send sendFunc, edition string, have Release) (Release, error) {
+	resp, rel, err := s.request(ctx, send, http.MethodHead, edition, have)
+	if err != nil {
+		return Release{}, err
+	}
+	resp.Body.Close()
+	return rel, nil
+}
+
+// fetch writes the object of edition to dst. On error, dst is removed, so
+// that no truncated object is taken for a partial download.
+func (s *S3Source) fetch(ctx context.Context, send sendFunc, edition string, have Release, dst string) (_ Release, err error) {
+	resp, rel, err := s.request(ctx, send, http.MethodGet, edition, have)
+	if err != nil {
+		return Release{}, err
+	}
+	defer resp.Body.Close()
+	key := fmt.Sprintf(s.Key, edition)
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return rel, err
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+	rel.Bytes, err = io.Copy(f, resp.Body)
+	DownloadBytesTotal.WithLabelValues(edition).Add(float64(rel.Bytes))
+	if err != nil {
+		return rel, err
+	}
+	if resp.ContentLength >= 0 && rel.Bytes != resp.ContentLength {
+		return rel, fmt.Errorf("short transfer: got %d of %d bytes", rel.Bytes, resp.ContentLength)
+	}
+	if err := f.Close(); err != nil {
+		return rel, err
+	}
+
+	sumResp, err := s.get(ctx, send, http.MethodGet, key+".sha256", nil)
+	if err != nil {
+		var se *StatusError
+		if errors.As(err, &se) && se.StatusCode == http.StatusNotFound {
+			return rel, nil
+		}
+		return rel, fmt.Errorf("checksum: %w", err)
+	}
+	defer sumResp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(sumResp.Body, 1024))
+	if err != nil {
+		return rel, fmt.Errorf("checksum: %w", err)
+	}
+	if rel.SHA256, err = parseChecksum(data); err != nil {
+		return rel, fmt.Errorf("checksum: %w", err)
+	}
+	return rel, nil
+}
@@ -0,0 +1,301 @@
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MaxmindSchedule      = "MAXMIND_SCHEDULE"
+	MaxmindStartupJitter = "MAXMIND_STARTUP_JITTER"
+)
+
+// Schedule decides when the Downloader runs.
+type Schedule interface {
+	// Next returns the first run time after t.
+	Next(t time.Time) time.Time
+}
+
+// Interval runs at a fixed interval.
+type Interval time.Duration
+
+func (i Interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+// DefaultSchedule is used unless WithSchedule is given.
+var DefaultSchedule Schedule = Interval(DefaultReloadInterval)
+
+// WithSchedule sets when Run downloads, overriding MAXMIND_SCHEDULE.
+func WithSchedule(s Schedule) Option {
+	return func(d *Downloader) {
+		d.schedule = s
+	}
+}
+
+// WithStartupJitter makes Run wait a random duration up to max before the
+// first download, so that many instances started together do not hit the
+// server at once. It overrides MAXMIND_STARTUP_JITTER.
+func WithStartupJitter(max time.Duration) Option {
+	return func(d *Downloader) {
+		d.jitter = max
+	}
+}
+
+// Run downloads the configured editions after the startup jitter and then
+// on the schedule, until ctx is done. It returns ctx.Err(), or an error if
+// the schedule never runs again.
+func (d *Downloader) Run(ctx context.Context) error {
+	if d.jitter > 0 {
+		wait := rand.N(d.jitter)
+		log.Printf("mmdb: first download in %s", wait.Round(time.Second))
+		if err := d.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+
+	return d.run(ctx, true)
+}
+
+// RunScheduled is like Run but first waits for the next scheduled time
+// instead of downloading right away, e.g. after an initial download. The
+// startup jitter does not apply.
+func (d *Downloader) RunScheduled(ctx context.Context) error {
+	return d.run(ctx, false)
+}
+
+func (d *Downloader) run(ctx context.Context, now bool) error {
+	for {
+		if now {
+			if _, err := d.DownloadDatabases(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("mmdb: scheduled download failed: %v", err)
+			}
+		}
+		now = true
+
+		next := d.schedule.Next(time.Now())
+		if next.IsZero() {
+			return errors.New("mmdb: schedule has no next run")
+		}
+		DownloadNextRunTimestamp.Set(float64(next.Unix()))
+		log.Printf("mmdb: next download at %s", next.Format(time.RFC3339))
+		if err := d.sleep(ctx, time.Until(next)); err != nil {
+			return err
+		}
+	}
+}
+
+// ParseSchedule parses a schedule:
+//
+//   - a duration such as "2h" for an Interval
+//   - "@publication" or "@publication/30m" for PublicationDays, checking
+//     every hour or the given interval
+//   - a cron expression, see ParseCron
+func ParseSchedule(s string) (Schedule, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return DefaultSchedule, nil
+	}
+	if rest, ok := strings.CutPrefix(s, "@publication"); ok {
+		every := time.Hour
+		if v, ok := strings.CutPrefix(rest, "/"); ok {
+			var err error
+			if every, err = time.ParseDuration(v); err != nil || every <= 0 {
+				return nil, fmt.Errorf("mmdb: invalid schedule %q", s)
+			}
+		} else if rest != "" {
+			return nil, fmt.Errorf("mmdb: invalid schedule %q", s)
+		}
+		return PublicationDays(every), nil
+	}
+	if i, err := time.ParseDuration(s); err == nil {
+		if i <= 0 {
+			return nil, fmt.Errorf("mmdb: invalid schedule %q", s)
+		}
+		return Interval(i), nil
+	}
+	return ParseCron(s)
+}
+
+// scheduleFromEnv reads MAXMIND_SCHEDULE and MAXMIND_STARTUP_JITTER.
+func scheduleFromEnv() (Schedule, time.Duration, error) {
+	schedule, err := ParseSchedule(os.Getenv(MaxmindSchedule))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", MaxmindSchedule, err)
+	}
+	var jitter time.Duration
+	if v := os.Getenv(MaxmindStartupJitter); v != "" {
+		if jitter, err = time.ParseDuration(v); err != nil || jitter < 0 {
+			return nil, 0, fmt.Errorf("%s: invalid duration %q", MaxmindStartupJitter, v)
+		}
+	}
+	return schedule, jitter, nil
+}
+
+// publicationDays are the UTC weekdays MaxMind publishes GeoIP2 and
+// GeoLite2 databases on, followed by the day after in case a release is
+// late.
+var publicationDays = map[time.Weekday]bool{
+	time.Tuesday:   true,
+	time.Wednesday: true,
+	time.Friday:    true,
+	time.Saturday:  true,
+}
+
+type publicationSchedule struct {
+	every time.Duration
+}
+
+// PublicationDays runs every interval on MaxMind's publication days,
+// Tuesdays and Fridays, and the day after each (UTC), and not at all on
+// other days.
+func PublicationDays(every time.Duration) Schedule {
+	return publicationSchedule{every: every}
+}
+
+func (s publicationSchedule) Next(t time.Time) time.Time {
+	next := t.Add(s.every)
+	for day := next.UTC(); !publicationDays[day.Weekday()]; {
+		day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, time.UTC)
+		next = day
+	}
+	return next.In(t.Location())
+}
+
+// cronSchedule matches times against the fields of a cron expression,
+// stored as bit sets.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny are set for a day field starting with "*"; if
+	// both day fields are restricted, either may match, as in cron.
+	domAny, dowAny bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a standard five field cron expression, "minute hour
+// day-of-month month day-of-week", with lists, ranges, steps and the
+// macros @yearly, @monthly, @weekly, @daily and @hourly. Times are matched
+// in the location of the time passed to Next. For example, "30 6 * * 2,5"
+// runs at 6:30 on Tuesdays and Fridays.
+func ParseCron(expr string) (Schedule, error) {
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("mmdb: cron expression %q needs 5 fields", expr)
+	}
+
+	var s cronSchedule
+	for i, f := range []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	} {
+		bits, err := parseCronField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("mmdb: cron expression %q: %w", expr, err)
+		}
+		*f.bits = bits
+	}
+	// 7 is Sunday as well
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = strings.HasPrefix(fields[2], "*")
+	s.dowAny = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of "*", "n", "a-b", each
+// optionally followed by "/step".
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value %q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (s cronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// a valid expression matches within a few years
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	// e.g. "0 0 31 2 *", which never matches
+	return time.Time{}
+}
@@ -0,0 +1,218 @@
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	// a Monday
+	from := time.Date(2024, time.March, 4, 10, 17, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr     string
+		expected time.Time
+		wantErr  bool
+	}{
+		{expr: "* * * * *", expected: time.Date(2024, time.March, 4, 10, 18, 0, 0, time.UTC)},
+		{expr: "*/15 * * * *", expected: time.Date(2024, time.March, 4, 10, 30, 0, 0, time.UTC)},
+		{expr: "0 6 * * *", expected: time.Date(2024, time.March, 5, 6, 0, 0, 0, time.UTC)},
+		{expr: "30 6 * * 2,5", expected: time.Date(2024, time.March, 5, 6, 30, 0, 0, time.UTC)},
+		{expr: "0 0 * * 7", expected: time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 9-17/4 * * 1-5", expected: time.Date(2024, time.March, 4, 13, 0, 0, 0, time.UTC)},
+		{expr: "0 0 1 * 5", expected: time.Date(2024, time.March, 8, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 */1 * 1", expected: time.Date(2024, time.March, 11, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 */2 * 1", expected: time.Date(2024, time.March, 11, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 13 * */1", expected: time.Date(2024, time.March, 13, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 29 2 *", expected: time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{expr: "@monthly", expected: time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 31 2 *"},
+		{expr: "0 0 * *", wantErr: true},
+		{expr: "60 * * * *", wantErr: true},
+		{expr: "*/0 * * * *", wantErr: true},
+		{expr: "5-1 * * * *", wantErr: true},
+		{expr: "x * * * *", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := ParseCron(tt.expr)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCron: %v", err)
+			}
+			if got := s.Next(from); !got.Equal(tt.expected) {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestPublicationDays(t *testing.T) {
+	s := PublicationDays(time.Hour)
+
+	tests := []struct {
+		name     string
+		from     time.Time
+		expected time.Time
+	}{
+		{
+			name:     "Tuesday",
+			from:     time.Date(2024, time.March, 5, 10, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, time.March, 5, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Wednesday Night",
+			from:     time.Date(2024, time.March, 6, 23, 30, 0, 0, time.UTC),
+			expected: time.Date(2024, time.March, 8, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Sunday",
+			from:     time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, time.March, 12, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Next(tt.from); !got.Equal(tt.expected) {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		schedule string
+		expected Schedule
+		wantErr  bool
+	}{
+		{schedule: "", expected: DefaultSchedule},
+		{schedule: "30m", expected: Interval(30 * time.Minute)},
+		{schedule: "@publication", expected: PublicationDays(time.Hour)},
+		{schedule: "@publication/15m", expected: PublicationDays(15 * time.Minute)},
+		{schedule: "@publication/never", wantErr: true},
+		{schedule: "-1h", wantErr: true},
+		{schedule: "tomorrow", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.schedule, func(t *testing.T) {
+			got, err := ParseSchedule(tt.schedule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %#v, got %#v", tt.expected, got)
+			}
+		})
+	}
+
+	if s, err := ParseSchedule("0 6 * * 2,5"); err != nil {
+		t.Errorf("expected cron expression to be parsed, got %v", err)
+	} else if _, ok := s.(cronSchedule); !ok {
+		t.Errorf("expected cron schedule, got %#v", s)
+	}
+}
+
+func TestRun(t *testing.T) {
+	d, srv := newTestDownloader(t, WithSchedule(Interval(time.Hour)), WithStartupJitter(time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var waits []time.Duration
+	d.sleep = func(ctx context.Context, wait time.Duration) error {
+		waits = append(waits, wait)
+		if len(waits) == 3 {
+			cancel()
+		}
+		return ctx.Err()
+	}
+
+	if err := d.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if len(waits) != 3 {
+		t.Fatalf("expected 3 waits, got %v", waits)
+	}
+	if waits[0] < 0 || waits[0] >= time.Minute {
+		t.Errorf("expected startup jitter below 1m, got %s", waits[0])
+	}
+	for _, wait := range waits[1:] {
+		if wait <= 59*time.Minute || wait > time.Hour {
+			t.Errorf("expected to wait about 1h, got %s", wait)
+		}
+	}
+
+	// one full download, then conditional requests only
+	if got := archiveDownloads(srv, CityDatabase); got != 1 {
+		t.Errorf("expected 1 download, got %d", got)
+	}
+}
+
+func TestRunScheduled(t *testing.T) {
+	d, srv := newTestDownloader(t, WithSchedule(Interval(time.Hour)), WithStartupJitter(time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var waits []time.Duration
+	var downloads []int
+	d.sleep = func(ctx context.Context, wait time.Duration) error {
+		waits = append(waits, wait)
+		downloads = append(downloads, archiveDownloads(srv, CityDatabase))
+		if len(waits) == 2 {
+			cancel()
+		}
+		return ctx.Err()
+	}
+
+	if err := d.RunScheduled(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	// no jitter, and no download before the first scheduled run
+	for _, wait := range waits {
+		if wait <= 59*time.Minute || wait > time.Hour {
+			t.Errorf("expected to wait about 1h, got %s", wait)
+		}
+	}
+	if expected := []int{0, 1}; !reflect.DeepEqual(downloads, expected) {
+		t.Errorf("expected downloads %v before each wait, got %v", expected, downloads)
+	}
+}
+
+func TestRunNoNextRun(t *testing.T) {
+	never, err := ParseCron("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := newTestDownloader(t, WithSchedule(never))
+	if err := d.Run(context.Background()); err == nil {
+		t.Error("expected error for a schedule without next run, got nil")
+	}
+}
+
+func TestScheduleFromEnv(t *testing.T) {
+	t.Setenv(MaxmindSchedule, "@publication")
+	t.Setenv(MaxmindStartupJitter, "5m")
+	s, jitter, err := scheduleFromEnv()
+	if err != nil {
+		t.Fatalf("scheduleFromEnv: %v", err)
+	}
+	if s != PublicationDays(time.Hour) || jitter != 5*time.Minute {
+		t.Errorf("expected publication days and 5m jitter, got %#v, %s", s, jitter)
+	}
+
+	t.Setenv(MaxmindStartupJitter, "soon")
+	if _, _, err := scheduleFromEnv(); err == nil {
+		t.Error("expected error for invalid jitter, got nil")
+	}
+}
@@ -110,3 +110,134 @@ func TestAuthMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleIndex(t *testing.T) {
+	c := newTestClient(t)
+	s, err := NewServer(c, "")
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	handler := s.Handler()
+
+	tests := []struct {
+		name                string
+		path                string
+		accept              string
+		remoteAddr          string
+		expectedStatus      int
+		expectedContentType string
+		expectedBody        []string
+	}{
+		{
+			name:                "Text Format",
+			path:                "/?ip=81.2.69.142&format=text",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "text/plain; charset=utf-8",
+			expectedBody:        []string{"81.2.69.142"},
+		},
+		{
+			name:                "Text Accept Header",
+			path:                "/",
+			accept:              "text/plain",
+			remoteAddr:          "216.160.83.56:1234",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "text/plain; charset=utf-8",
+			expectedBody:        []string{"216.160.83.56"},
+		},
+		{
+			name:                "JSON Format",
+			path:                "/?ip=216.160.83.56&format=json",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/json",
+			expectedBody:        []string{`"city":"Milton"`, `"country_code":"US"`, `"asn":"Qwest Communications Company, LLC"`, `"network":"216.160.83.0/24"`},
+		},
+		{
+			name:                "JSON Accept Header",
+			path:                "/?ip=2001:218::1",
+			accept:              "application/json",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/json",
+			expectedBody:        []string{`"ip_type":6`, `"country_code":"JP"`},
+		},
+		{
+			name:                "HTML Default",
+			path:                "/?ip=81.2.69.142",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "text/html; charset=utf-8",
+			expectedBody:        []string{"<title>IP Info - 81.2.69.142</title>", "London", "GB", "City DB Build Date:"},
+		},
+		{
+			name:           "Invalid IP",
+			path:           "/?ip=not-an-ip&format=json",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{"Invalid IP address"},
+		},
+		{
+			name:           "Unknown Path",
+			path:           "/favicon.ico",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if tt.expectedContentType != "" && rr.Header().Get("Content-Type") != tt.expectedContentType {
+				t.Errorf("expected content type %q, got %q", tt.expectedContentType, rr.Header().Get("Content-Type"))
+			}
+			for _, want := range tt.expectedBody {
+				if !strings.Contains(rr.Body.String(), want) {
+					t.Errorf("expected body to contain %q, got %q", want, rr.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestHandleIndexUnauthorized(t *testing.T) {
+	c := newTestClient(t)
+	s, err := NewServer(c, "secret")
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	handler := s.Handler()
+
+	req := httptest.NewRequest("GET", "/?ip=81.2.69.142", nil)
+	req.Header.Set("Accept", "text/html")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), "London") {
+		t.Errorf("body must not contain lookup results if unauthorized")
+	}
+	if strings.Contains(rr.Body.String(), s.AuthToken) {
+		t.Errorf("body must not contain auth token if unauthorized")
+	}
+
+	req = httptest.NewRequest("GET", "/?ip=81.2.69.142&format=json&auth=secret", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"city":"London"`) {
+		t.Errorf("expected lookup result, got %q", rr.Body.String())
+	}
+}
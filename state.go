@@ -0,0 +1,131 @@
+package mmdb
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+const stateSuffix = ".state.json"
+
+func statePath(dataDir, name string) string {
+	return path.Join(dataDir, name+stateSuffix)
+}
+
+// editionState is persisted next to each database and records which
+// release the local file came from. It drives conditional requests, so that
+// freshness does not depend on file timestamps.
+type editionState struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	SHA256       string `json:"sha256,omitempty"`
+	BuildEpoch   uint   `json:"build_epoch"`
+
+	// Partial describes the release a leftover part file belongs to.
+	Partial *partialState `json:"partial,omitempty"`
+}
+
+// partialState holds the validators of a partially downloaded archive,
+// used with If-Range to resume it.
+type partialState struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// validator returns the value to send as If-Range.
+func (p *partialState) validator() string {
+	if p.ETag != "" {
+		return p.ETag
+	}
+	return p.LastModified
+}
+
+// loadState returns the saved state of edition if it still describes the
+// local database, i.e. the file exists and carries the recorded build
+// epoch. Otherwise, e.g. after a restore from backup, it returns a state
+// without validators so that the edition is downloaded unconditionally;
+// only a partial download is kept.
+func loadState(dataDir, edition string) editionState {
+	data, err := os.ReadFile(statePath(dataDir, edition))
+	if err != nil {
+		return editionState{}
+	}
+	var st editionState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return editionState{}
+	}
+
+	epoch, err := buildEpoch(dbPath(dataDir, edition))
+	if err != nil || epoch != st.BuildEpoch {
+		return editionState{Partial: st.Partial}
+	}
+	return st
+}
+
+// saveState atomically writes the state of edition. An empty state
+// removes the file.
+func saveState(dataDir, edition string, st editionState) error {
+	p := statePath(dataDir, edition)
+	if st == (editionState{}) {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(p+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+// setConditional adds the validators of st to a request header.
+func (st editionState) setConditional(h http.Header) {
+	if st.ETag != "" {
+		h.Set("If-None-Match", st.ETag)
+	}
+	if st.LastModified != "" {
+		h.Set("If-Modified-Since", st.LastModified)
+	}
+}
+
+// release returns the release st describes, to pass to a Source.
+func (st editionState) release() Release {
+	return Release{ETag: st.ETag, LastModified: st.lastModified()}
+}
+
+// lastModified parses the saved Last-Modified value, zero if unknown.
+func (st editionState) lastModified() time.Time {
+	t, _ := http.ParseTime(st.LastModified)
+	return t
+}
+
+// buildEpoch reads the build epoch from the metadata of the database at
+// path. It also serves as a check that path is a valid database.
+func buildEpoch(path string) (uint, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	return reader.Metadata.BuildEpoch, nil
+}
+
+// verifyDatabase checks the metadata, search tree and data section of the
+// database at path.
+func verifyDatabase(path string) error {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return reader.Verify()
+}
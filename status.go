@@ -0,0 +1,182 @@
+package mmdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// EditionStatus describes the local and remote state of an edition, see
+// Downloader.Status.
+type EditionStatus struct {
+	Edition string
+	// BuildEpoch is read from the metadata of the local database, 0 if
+	// there is none.
+	BuildEpoch uint
+	// RemoteTime is the Last-Modified time of the current release of the
+	// source, zero if unknown.
+	RemoteTime time.Time
+	// Pending reports whether the next download would install a release.
+	Pending bool
+	// Backups are the backup generations on disk, newest first.
+	Backups []Generation
+	Err     error
+}
+
+// BuildTime returns the build epoch as a time, zero without a database.
+func (s EditionStatus) BuildTime() time.Time {
+	if s.BuildEpoch == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(s.BuildEpoch), 0).UTC()
+}
+
+// MarshalJSON renders s for scripts, with times in RFC 3339 and the
+// backups as their build epochs.
+func (s EditionStatus) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Edition    string     `json:"edition"`
+		BuildEpoch uint       `json:"build_epoch,omitempty"`
+		BuildTime  *time.Time `json:"build_time,omitempty"`
+		RemoteTime *time.Time `json:"remote_time,omitempty"`
+		Pending    bool       `json:"pending"`
+		Backups    []uint     `json:"backups"`
+		Error      string     `json:"error,omitempty"`
+	}{
+		Edition:    s.Edition,
+		BuildEpoch: s.BuildEpoch,
+		Pending:    s.Pending,
+		Backups:    []uint{},
+	}
+	if t := s.BuildTime(); !t.IsZero() {
+		v.BuildTime = &t
+	}
+	if !s.RemoteTime.IsZero() {
+		t := s.RemoteTime.UTC()
+		v.RemoteTime = &t
+	}
+	for _, g := range s.Backups {
+		v.Backups = append(v.Backups, g.BuildEpoch)
+	}
+	if s.Err != nil {
+		v.Error = s.Err.Error()
+	}
+	return json.Marshal(v)
+}
+
+// StatusReport collects the status of each edition in the order they were
+// requested.
+type StatusReport struct {
+	Editions []EditionStatus `json:"editions"`
+}
+
+// Pending returns the editions a download would update.
+func (r *StatusReport) Pending() []EditionStatus {
+	var pending []EditionStatus
+	for _, s := range r.Editions {
+		if s.Pending {
+			pending = append(pending, s)
+		}
+	}
+	return pending
+}
+
+// Err joins the errors of all editions that could not be checked,
+// prefixed with the edition.
+func (r *StatusReport) Err() error {
+	var errs []error
+	for _, s := range r.Editions {
+		if s.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Edition, s.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// String renders the report as a table, one line per edition.
+func (r *StatusReport) String() string {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "EDITION\tBUILD EPOCH\tBUILD\tREMOTE\tPENDING\tBACKUPS\tERROR")
+	for _, s := range r.Editions {
+		epoch, build, remote := "-", "-", "-"
+		if s.BuildEpoch != 0 {
+			epoch = strconv.FormatUint(uint64(s.BuildEpoch), 10)
+			build = s.BuildTime().Format(time.RFC3339)
+		}
+		if !s.RemoteTime.IsZero() {
+			remote = s.RemoteTime.UTC().Format(time.RFC3339)
+		}
+		pending := "no"
+		if s.Pending {
+			pending = "yes"
+		} else if s.Err != nil {
+			pending = "-"
+		}
+		backups := make([]string, len(s.Backups))
+		for i, g := range s.Backups {
+			backups[i] = strconv.FormatUint(uint64(g.BuildEpoch), 10)
+		}
+		errMsg := ""
+		if s.Err != nil {
+			errMsg = s.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.Edition, epoch, build, remote, pending, strings.Join(backups, ","), errMsg)
+	}
+	tw.Flush()
+	return b.String()
+}
+
+// Status checks editions, defaulting to the configured ones, against the
+// source without writing anything: it reads the build epoch of each local
+// database and its backups, and asks the source whether there is a new
+// release. The Source has to implement Checker for the latter. Errors are
+// reported per edition.
+func (d *Downloader) Status(ctx context.Context, editions ...string) *StatusReport {
+	if len(editions) == 0 {
+		editions = d.Editions
+	}
+	d.refreshCredentials()
+
+	report := &StatusReport{Editions: make([]EditionStatus, len(editions))}
+	for i, edition := range editions {
+		report.Editions[i] = d.status(ctx, edition)
+	}
+	return report
+}
+
+func (d *Downloader) status(ctx context.Context, edition string) (s EditionStatus) {
+	s.Edition = edition
+	defer func() { s.Err = d.redact(s.Err) }()
+
+	gens, err := d.Generations(edition)
+	if err != nil {
+		s.Err = err
+		return s
+	}
+	for _, g := range gens {
+		if g.Current {
+			s.BuildEpoch = g.BuildEpoch
+		} else {
+			s.Backups = append(s.Backups, g)
+		}
+	}
+
+	st := loadState(d.BasePath, edition)
+	rel, err := d.check(ctx, edition, st.release())
+	switch {
+	case errors.Is(err, ErrNotModified):
+		s.RemoteTime = st.lastModified()
+	case err != nil:
+		s.Err = err
+	default:
+		s.RemoteTime, s.Pending = rel.LastModified, true
+	}
+	return s
+}
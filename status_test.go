@@ -0,0 +1,146 @@
+package mmdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NoUmlautsAllowed/go-mmdb/mmdbtest"
+)
+
+func TestStatus(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	ctx := context.Background()
+
+	// nothing installed yet
+	s := d.Status(ctx, CityDatabase).Editions[0]
+	if s.Err != nil || !s.Pending || s.BuildEpoch != 0 || !s.RemoteTime.Equal(testModTime) {
+		t.Errorf("expected pending release of %s, got %+v", testModTime, s)
+	}
+	if entries, err := os.ReadDir(d.BasePath); err != nil || len(entries) != 0 {
+		t.Errorf("expected nothing written, got %v, %v", entries, err)
+	}
+	for _, r := range srv.Requests() {
+		if r.Method != http.MethodHead {
+			t.Errorf("expected only HEAD requests, got %s", r.Method)
+		}
+	}
+
+	if err := d.downloadOne(ctx, CityDatabase).Err; err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	s = d.Status(ctx, CityDatabase).Editions[0]
+	if s.Err != nil || s.Pending || s.BuildEpoch != mmdbtest.BuildEpoch || !s.RemoteTime.Equal(testModTime) {
+		t.Errorf("expected installed release to be current, got %+v", s)
+	}
+
+	newData, err := mmdbtest.Database(CityDatabase, mmdbtest.BuildEpoch+3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetDatabase(CityDatabase, newData, testModTime.Add(time.Hour))
+	s = d.Status(ctx, CityDatabase).Editions[0]
+	if !s.Pending || !s.RemoteTime.Equal(testModTime.Add(time.Hour)) || s.BuildEpoch != mmdbtest.BuildEpoch {
+		t.Errorf("expected newer release to be pending, got %+v", s)
+	}
+
+	if err := d.downloadOne(ctx, CityDatabase).Err; err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	s = d.Status(ctx, CityDatabase).Editions[0]
+	if s.BuildEpoch != mmdbtest.BuildEpoch+3600 || len(s.Backups) != 1 || s.Backups[0].BuildEpoch != mmdbtest.BuildEpoch {
+		t.Errorf("expected previous build as backup, got %+v", s)
+	}
+}
+
+func TestStatusSources(t *testing.T) {
+	cache := t.TempDir()
+	d := newSourceDownloader(t, WithSource(DirSource{Dir: cache}))
+	ctx := context.Background()
+
+	if s := d.Status(ctx, CityDatabase).Editions[0]; s.Err == nil {
+		t.Errorf("expected error for a missing file, got %+v", s)
+	}
+	if err := mmdbtest.WriteDatabase(dbPath(cache, CityDatabase), CityDatabase, testBuildEpoch); err != nil {
+		t.Fatal(err)
+	}
+	if s := d.Status(ctx, CityDatabase).Editions[0]; s.Err != nil || !s.Pending {
+		t.Errorf("expected pending copy, got %+v", s)
+	}
+	if err := d.downloadOne(ctx, CityDatabase).Err; err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if s := d.Status(ctx, CityDatabase).Editions[0]; s.Err != nil || s.Pending {
+		t.Errorf("expected copy to be current, got %+v", s)
+	}
+
+	// a Source without Check
+	custom := sourceFunc(func(context.Context, string, Release, string) (Release, error) { return Release{}, nil })
+	d = newSourceDownloader(t, WithSource(custom))
+	if err := d.Status(ctx, CityDatabase).Err(); err == nil || !strings.HasPrefix(err.Error(), CityDatabase+": ") {
+		t.Errorf("expected error for a source that cannot check, got %v", err)
+	}
+}
+
+type sourceFunc func(ctx context.Context, edition string, have Release, path string) (Release, error)
+
+func (f sourceFunc) Fetch(ctx context.Context, edition string, have Release, path string) (Release, error) {
+	return f(ctx, edition, have, path)
+}
+
+func TestStatusReport(t *testing.T) {
+	report := &StatusReport{Editions: []EditionStatus{
+		{
+			Edition:    CityDatabase,
+			BuildEpoch: mmdbtest.BuildEpoch,
+			RemoteTime: testModTime,
+			Backups:    []Generation{{Edition: CityDatabase, BuildEpoch: mmdbtest.BuildEpoch - 3600}},
+		},
+		{Edition: ASNDatabase, RemoteTime: testModTime, Pending: true},
+		{Edition: CountryDatabase, Err: errors.New("boom")},
+	}}
+
+	if got := report.Pending(); len(got) != 1 || got[0].Edition != ASNDatabase {
+		t.Errorf("expected %s pending, got %+v", ASNDatabase, got)
+	}
+
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected header and 3 rows, got %q", report.String())
+	}
+	for i, want := range [][]string{
+		{"EDITION", "BUILD EPOCH", "PENDING", "BACKUPS"},
+		{CityDatabase, "1700000000", "2023-11-14T22:13:20Z", "2023-11-14T12:00:00Z", "no", "1699996400"},
+		{ASNDatabase, "yes"},
+		{CountryDatabase, "boom"},
+	} {
+		for _, field := range want {
+			if !strings.Contains(lines[i], field) {
+				t.Errorf("line %d: expected %q in %q", i, field, lines[i])
+			}
+		}
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Editions []map[string]any `json:"editions"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	city := decoded.Editions[0]
+	if city["build_epoch"] != float64(mmdbtest.BuildEpoch) || city["build_time"] != "2023-11-14T22:13:20Z" || city["pending"] != false {
+		t.Errorf("unexpected JSON for %s: %v", CityDatabase, city)
+	}
+	if country := decoded.Editions[2]; country["error"] != "boom" || country["build_epoch"] != nil {
+		t.Errorf("unexpected JSON for %s: %v", CountryDatabase, country)
+	}
+}
@@ -0,0 +1,41 @@
+package mmdb
+
+import (
+	"testing"
+
+	"github.com/NoUmlautsAllowed/go-mmdb/mmdbtest"
+)
+
+// testBuildEpoch is the build epoch written into generated test databases
+// unless a test asks for a different one.
+const testBuildEpoch = mmdbtest.BuildEpoch
+
+// writeTestDatabases writes City, Country and ASN databases built from
+// mmdbtest.Records into dir.
+func writeTestDatabases(t testing.TB, dir string, buildEpoch int64) {
+	t.Helper()
+	for _, edition := range []string{CityDatabase, CountryDatabase, ASNDatabase} {
+		if err := mmdbtest.WriteDatabase(dbPath(dir, edition), edition, buildEpoch); err != nil {
+			t.Fatalf("write %s: %v", edition, err)
+		}
+	}
+}
+
+// newTestClient writes the test databases into a temporary directory and
+// returns a Client reading from it.
+func newTestClient(t testing.TB) *Client {
+	t.Helper()
+
+	dir := t.TempDir()
+	writeTestDatabases(t, dir, testBuildEpoch)
+	t.Setenv(MaxmindBasePath, dir)
+	t.Setenv(MaxmindEditionIds, "")
+	t.Setenv(MaxmindPinnedGenerations, "")
+
+	c, err := NewClient()
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
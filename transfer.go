@@ -0,0 +1,306 @@
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const partSuffix = ".part"
+
+func partPath(dataDir, name string) string {
+	return path.Join(dataDir, name+partSuffix)
+}
+
+// errIdleTimeout is returned when a transfer stalls for longer than the
+// idle timeout.
+var errIdleTimeout = errors.New("mmdb: transfer idle timeout")
+
+// Timeouts bounds the phases of a download instead of its total duration,
+// so that large archives on slow links are not cut off while they make
+// progress.
+type Timeouts struct {
+	// Connect bounds dialing and the TLS handshake.
+	Connect time.Duration
+	// Header bounds the wait for response headers after sending a request.
+	Header time.Duration
+	// Idle bounds the time between two reads of the response body.
+	Idle time.Duration
+}
+
+// DefaultTimeouts are used unless WithTimeouts is given.
+var DefaultTimeouts = Timeouts{
+	Connect: 10 * time.Second,
+	Header:  30 * time.Second,
+	Idle:    30 * time.Second,
+}
+
+// WithTimeouts sets the per-phase timeouts. Connect and Header only apply to
+// the default HTTP client, not to one given with WithClient.
+func WithTimeouts(t Timeouts) Option {
+	return func(d *Downloader) {
+		d.timeouts = t
+	}
+}
+
+// Progress reports the state of an archive transfer.
+type Progress struct {
+	Edition string
+	// Received is the number of archive bytes on disk, including bytes of
+	// a resumed earlier attempt.
+	Received int64
+	// Total is the archive size, or -1 if the server did not send it.
+	Total int64
+	// Rate is the average transfer rate of the current attempt in bytes
+	// per second.
+	Rate float64
+	// Done is set on the last report of a successful transfer.
+	Done bool
+}
+
+// String formats p as e.g. "GeoLite2-City 12.0 MiB / 30.0 MiB (40%) 2.0 MiB/s".
+func (p Progress) String() string {
+	var b strings.Builder
+	b.WriteString(p.Edition + " " + formatBytes(p.Received))
+	if p.Total > 0 {
+		fmt.Fprintf(&b, " / %s (%d%%)", formatBytes(p.Total), p.Received*100/p.Total)
+	}
+	fmt.Fprintf(&b, " %s/s", formatBytes(int64(p.Rate)))
+	return b.String()
+}
+
+// WithProgress registers fn to be called while archives are downloaded, at
+// most every progressInterval and once when a transfer completes. With
+// parallel downloads fn is called concurrently for different editions.
+func WithProgress(fn func(Progress)) Option {
+	return func(d *Downloader) {
+		d.progress = fn
+	}
+}
+
+const progressInterval = 250 * time.Millisecond
+
+// archiveResponse describes the outcome of fetchArchive.
+type archiveResponse struct {
+	NotModified bool
+	Header      http.Header
+	// Bytes is the number of bytes received in this run.
+	Bytes int64
+}
+
+// fetchArchive downloads the archive at url into part, resuming
+// a partial download recorded in st with a Range request. Interrupted
+// transfers are resumed; resumes and retries share the retry policy's
+// MaxAttempts and MaxElapsed. st.Partial is kept up to date and saved so
+// that later runs can resume as well.
+func (d *Downloader) fetchArchive(ctx context.Context, db, url string, base http.Header, part string, st *editionState) (archiveResponse, error) {
+	budget := newRetryBudget()
+
+	var received int64
+	for {
+		offset := int64(0)
+		if info, err := os.Stat(part); err == nil && st.Partial != nil {
+			offset = info.Size()
+		}
+
+		header := base.Clone()
+		st.setConditional(header)
+		if offset > 0 {
+			header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			header.Set("If-Range", st.Partial.validator())
+		}
+
+		reqCtx, cancel := context.WithCancel(ctx)
+		resp, err := d.sendWithin(reqCtx, budget, "GET", url, header, nil)
+		if err != nil {
+			cancel()
+			var se *StatusError
+			if errors.As(err, &se) && se.StatusCode == http.StatusRequestedRangeNotSatisfiable && !d.retry.exhausted(budget) {
+				// the part file does not fit the current release
+				d.discardPartial(db, st)
+				continue
+			}
+			return archiveResponse{Bytes: received}, err
+		}
+
+		if resp.StatusCode == http.StatusNotModified {
+			resp.Body.Close()
+			cancel()
+			return archiveResponse{NotModified: true, Header: resp.Header}, nil
+		}
+
+		n, err := d.receive(db, resp, part, offset, st, cancel)
+		received += n
+		resp.Body.Close()
+		cancel()
+		if err == nil {
+			return archiveResponse{Header: resp.Header, Bytes: received}, nil
+		}
+		if ctx.Err() != nil || d.retry.exhausted(budget) {
+			return archiveResponse{Bytes: received}, err
+		}
+
+		log.Printf("mmdb [%s] transfer interrupted after %s, resuming: %v", db, formatBytes(offset+n), d.redact(err))
+		if err := d.sleep(ctx, d.retry.backoff(budget.attempts)); err != nil {
+			return archiveResponse{Bytes: received}, err
+		}
+	}
+}
+
+// receive writes the body of resp to the part file, appending to it for
+// a 206 response starting at offset and replacing it otherwise.
+func (d *Downloader) receive(db string, resp *http.Response, part string, offset int64, st *editionState, cancel context.CancelFunc) (int64, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resp.StatusCode == http.StatusPartialContent {
+		start, err := contentRangeStart(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return 0, err
+		}
+		if start != offset {
+			return 0, fmt.Errorf("range starts at %d, want %d", start, offset)
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+	} else {
+		offset = 0
+	}
+
+	st.Partial = &partialState{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if st.Partial.validator() == "" {
+		st.Partial = nil
+	}
+	if err := saveState(d.BasePath, db, *st); err != nil {
+		log.Printf("mmdb [%s] save state: %v", db, err)
+	}
+
+	f, err := os.OpenFile(part, flags, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+
+	body := newIdleReader(resp.Body, d.timeouts.Idle, cancel)
+	defer body.stop()
+
+	var (
+		n        int64
+		start    = time.Now()
+		reported = start
+		buf      = make([]byte, 32<<10)
+	)
+	report := func(done bool) {
+		if d.progress == nil {
+			return
+		}
+		rate := 0.0
+		if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+			rate = float64(n) / elapsed
+		}
+		d.progress(Progress{Edition: db, Received: offset + n, Total: total, Rate: rate, Done: done})
+	}
+
+	for {
+		m, rerr := body.Read(buf)
+		if m > 0 {
+			if _, err := f.Write(buf[:m]); err != nil {
+				return n, err
+			}
+			n += int64(m)
+			DownloadBytesTotal.WithLabelValues(db).Add(float64(m))
+			if time.Since(reported) >= progressInterval {
+				reported = time.Now()
+				report(false)
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+
+	if total >= 0 && offset+n != total {
+		return n, fmt.Errorf("short transfer: got %d of %d bytes", offset+n, total)
+	}
+	report(true)
+	return n, f.Close()
+}
+
+// discardPartial removes the part file and forgets its validators.
+func (d *Downloader) discardPartial(db string, st *editionState) {
+	os.Remove(partPath(d.BasePath, db))
+	if st.Partial != nil {
+		st.Partial = nil
+		if err := saveState(d.BasePath, db, *st); err != nil {
+			log.Printf("mmdb [%s] save state: %v", db, err)
+		}
+	}
+}
+
+// contentRangeStart parses the first byte position of a Content-Range
+// header such as "bytes 100-199/200".
+func contentRangeStart(v string) (int64, error) {
+	rest, ok := strings.CutPrefix(v, "bytes ")
+	if !ok {
+		return 0, fmt.Errorf("malformed Content-Range %q", v)
+	}
+	first, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, fmt.Errorf("malformed Content-Range %q", v)
+	}
+	return strconv.ParseInt(first, 10, 64)
+}
+
+// idleReader calls cancel when no Read returns within timeout, and reports
+// errIdleTimeout instead of the resulting cancellation error.
+type idleReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func newIdleReader(r io.Reader, timeout time.Duration, cancel context.CancelFunc) *idleReader {
+	ir := &idleReader{r: r, timeout: timeout}
+	if timeout > 0 {
+		ir.timer = time.AfterFunc(timeout, func() {
+			ir.expired.Store(true)
+			cancel()
+		})
+	}
+	return ir
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if ir.timer != nil {
+		if err != nil && ir.expired.Load() {
+			return n, errIdleTimeout
+		}
+		ir.timer.Reset(ir.timeout)
+	}
+	return n, err
+}
+
+func (ir *idleReader) stop() {
+	if ir.timer != nil {
+		ir.timer.Stop()
+	}
+}
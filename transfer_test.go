@@ -0,0 +1,260 @@
+package mmdb
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NoUmlautsAllowed/go-mmdb/mmdbtest"
+)
+
+// setTestArchive publishes a City archive and returns its bytes.
+func setTestArchive(t testing.TB, srv *mmdbtest.Server, buildEpoch int64, modTime time.Time) []byte {
+	t.Helper()
+	data, err := mmdbtest.Database(CityDatabase, buildEpoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := mmdbtest.Archive(CityDatabase, modTime, map[string][]byte{
+		CityDatabase + ".mmdb": data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetArchive(CityDatabase, archive, modTime)
+	return archive
+}
+
+// archiveRequests returns the GET requests for the City archive.
+func archiveRequests(srv *mmdbtest.Server) []mmdbtest.Request {
+	var reqs []mmdbtest.Request
+	for _, r := range srv.Requests() {
+		if r.Method == http.MethodGet && r.Edition == CityDatabase && r.Suffix == "tar.gz" {
+			reqs = append(reqs, r)
+		}
+	}
+	return reqs
+}
+
+func TestDownloadResumesInterruptedTransfer(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	archive := setTestArchive(t, srv, testBuildEpoch, testModTime)
+	srv.InterruptNext(1000)
+
+	res := d.downloadOne(context.Background(), CityDatabase)
+	if res.Err != nil {
+		t.Fatalf("downloadOne: %v", res.Err)
+	}
+	if res.Bytes != int64(len(archive)) {
+		t.Errorf("expected %d bytes received in total, got %d", len(archive), res.Bytes)
+	}
+
+	reqs := archiveRequests(srv)
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 archive requests, got %d", len(reqs))
+	}
+	resumed := reqs[1]
+	if resumed.Header.Get("Range") != "bytes=1000-" || resumed.Header.Get("If-Range") == "" {
+		t.Errorf("expected ranged request with If-Range, got %v", resumed.Header)
+	}
+	if resumed.Status != http.StatusPartialContent {
+		t.Errorf("expected 206 response, got %d", resumed.Status)
+	}
+
+	if _, err := buildEpoch(dbPath(d.BasePath, CityDatabase)); err != nil {
+		t.Errorf("expected valid database after resume: %v", err)
+	}
+	if _, err := os.Stat(partPath(d.BasePath, CityDatabase)); !os.IsNotExist(err) {
+		t.Errorf("expected part file to be removed, got %v", err)
+	}
+	if st := loadState(d.BasePath, CityDatabase); st.Partial != nil {
+		t.Errorf("expected partial state to be cleared, got %+v", st.Partial)
+	}
+}
+
+func TestDownloadResumesAcrossRuns(t *testing.T) {
+	d, srv := newTestDownloader(t, WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	archive := setTestArchive(t, srv, testBuildEpoch, testModTime)
+	srv.InterruptNext(500)
+	ctx := context.Background()
+
+	if err := d.downloadOne(ctx, CityDatabase).Err; err == nil {
+		t.Fatal("expected interrupted download to fail, got nil")
+	}
+	info, err := os.Stat(partPath(d.BasePath, CityDatabase))
+	if err != nil {
+		t.Fatalf("expected part file to be kept: %v", err)
+	}
+	if info.Size() != 500 {
+		t.Errorf("expected 500 bytes in part file, got %d", info.Size())
+	}
+	if st := loadState(d.BasePath, CityDatabase); st.Partial == nil || st.Partial.ETag == "" {
+		t.Errorf("expected partial validators to be saved, got %+v", st)
+	}
+
+	res := d.downloadOne(ctx, CityDatabase)
+	if res.Err != nil {
+		t.Fatalf("resumed download: %v", res.Err)
+	}
+	if res.Bytes != int64(len(archive))-500 {
+		t.Errorf("expected only the remaining %d bytes to be received, got %d", len(archive)-500, res.Bytes)
+	}
+	if reqs := archiveRequests(srv); reqs[len(reqs)-1].Header.Get("Range") != "bytes=500-" {
+		t.Errorf("expected resumed range request, got %v", reqs[len(reqs)-1].Header)
+	}
+}
+
+func TestDownloadResumeReleaseChanged(t *testing.T) {
+	d, srv := newTestDownloader(t, WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	setTestArchive(t, srv, testBuildEpoch, testModTime)
+	srv.InterruptNext(1000)
+	ctx := context.Background()
+
+	if err := d.downloadOne(ctx, CityDatabase).Err; err == nil {
+		t.Fatal("expected interrupted download to fail, got nil")
+	}
+
+	const newEpoch = testBuildEpoch + 3600
+	setTestArchive(t, srv, newEpoch, testModTime.Add(time.Hour))
+
+	if err := d.downloadOne(ctx, CityDatabase).Err; err != nil {
+		t.Fatalf("download after release change: %v", err)
+	}
+	reqs := archiveRequests(srv)
+	if last := reqs[len(reqs)-1]; last.Status != http.StatusOK {
+		t.Errorf("expected If-Range mismatch to return the full archive, got %d", last.Status)
+	}
+	if epoch, err := buildEpoch(dbPath(d.BasePath, CityDatabase)); err != nil || epoch != newEpoch {
+		t.Errorf("expected build epoch %d, got %d (%v)", newEpoch, epoch, err)
+	}
+}
+
+func TestDownloadProgress(t *testing.T) {
+	var reports []Progress
+	d, srv := newTestDownloader(t, WithProgress(func(p Progress) {
+		reports = append(reports, p)
+	}))
+	archive := setTestArchive(t, srv, testBuildEpoch, testModTime)
+
+	if err := d.downloadOne(context.Background(), CityDatabase).Err; err != nil {
+		t.Fatalf("downloadOne: %v", err)
+	}
+	if len(reports) == 0 {
+		t.Fatal("expected progress reports")
+	}
+	last := reports[len(reports)-1]
+	size := int64(len(archive))
+	if !last.Done || last.Edition != CityDatabase || last.Received != size || last.Total != size || last.Rate <= 0 {
+		t.Errorf("expected final report for the complete archive, got %+v", last)
+	}
+}
+
+func TestDownloadIdleTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer ts.Close()
+
+	t.Setenv(MaxmindAccountId, testAccountID)
+	t.Setenv(MaxmindLicenseKey, testLicenseKey)
+	t.Setenv(MaxmindBasePath, t.TempDir())
+	t.Setenv(MaxmindEditionIds, "")
+	d, err := NewDownloader(
+		WithURL(ts.URL+"/%s.tar.gz"),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+		WithTimeouts(Timeouts{Connect: time.Second, Header: time.Second, Idle: 50 * time.Millisecond}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	res := d.downloadOne(context.Background(), CityDatabase)
+	if !errors.Is(res.Err, errIdleTimeout) {
+		t.Fatalf("expected idle timeout, got %v", res.Err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected stalled transfer to be aborted quickly, took %s", elapsed)
+	}
+}
+
+func TestContentRangeStart(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+		wantErr  bool
+	}{
+		{value: "bytes 100-199/200", expected: 100},
+		{value: "bytes 0-0/*", expected: 0},
+		{value: "items 1-2/3", wantErr: true},
+		{value: "bytes 100", wantErr: true},
+		{value: "bytes x-1/2", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := contentRangeStart(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("contentRangeStart(%q): expected error", tt.value)
+			}
+			continue
+		}
+		if err != nil || got != tt.expected {
+			t.Errorf("contentRangeStart(%q): expected %d, got %d (%v)", tt.value, tt.expected, got, err)
+		}
+	}
+}
+
+func TestNewDownloaderClientTimeouts(t *testing.T) {
+	t.Setenv(MaxmindAccountId, testAccountID)
+	t.Setenv(MaxmindLicenseKey, testLicenseKey)
+	t.Setenv(MaxmindEditionIds, "")
+
+	d, err := NewDownloader(WithTimeouts(Timeouts{Connect: time.Second, Header: 2 * time.Second}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.client.Timeout != 0 {
+		t.Errorf("expected no total timeout, got %s", d.client.Timeout)
+	}
+	transport := httpTransport(t, d)
+	if transport.ResponseHeaderTimeout != 2*time.Second || transport.TLSHandshakeTimeout != time.Second {
+		t.Errorf("expected header and handshake timeouts to be set, got %s and %s",
+			transport.ResponseHeaderTimeout, transport.TLSHandshakeTimeout)
+	}
+	if !strings.Contains(d.url, "download.maxmind.com") {
+		t.Errorf("expected default URL, got %q", d.url)
+	}
+}
+
+func TestProgressString(t *testing.T) {
+	tests := []struct {
+		progress Progress
+		expected string
+	}{
+		{
+			progress: Progress{Edition: CityDatabase, Received: 12 << 20, Total: 30 << 20, Rate: 2 << 20},
+			expected: "GeoLite2-City 12.0 MiB / 30.0 MiB (40%) 2.0 MiB/s",
+		},
+		{
+			progress: Progress{Edition: ASNDatabase, Received: 512, Total: -1, Rate: 100},
+			expected: "GeoLite2-ASN 512 B 100 B/s",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.progress.String(); got != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, got)
+		}
+	}
+}